@@ -0,0 +1,167 @@
+// Package autosize adjusts the capacity of a cache based on memory pressure.
+package autosize
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Resizable is a cache whose capacity can be changed while it is in use, lru.Cache implements it
+type Resizable interface {
+	Capacity() int
+	Resize(cacheSize int) error
+}
+
+// Event describes a single capacity adjustment, or a failed sample when Err is set
+type Event struct {
+	Time  time.Time
+	Old   int
+	New   int
+	Used  uint64
+	Limit uint64
+	Err   error
+}
+
+func (e Event) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("autosize: sample failed: %v", e.Err)
+	}
+	return fmt.Sprintf("autosize: capacity %d -> %d (used %d of %d bytes)", e.Old, e.New, e.Used, e.Limit)
+}
+
+// Config configures a Controller, zero values are replaced by defaults
+type Config struct {
+	// Min and Max bound the capacity, both are required
+	Min, Max int
+	// Interval between samples when running, defaults to 5s
+	Interval time.Duration
+	// High is the used/limit ratio at or above which the cache shrinks, defaults to 0.85
+	High float64
+	// Low is the used/limit ratio at or below which the cache grows, defaults to 0.6
+	Low float64
+	// ShrinkFactor multiplies the capacity on shrink, defaults to 0.75
+	ShrinkFactor float64
+	// GrowFactor multiplies the capacity on grow, defaults to 1.25
+	GrowFactor float64
+	// OnEvent is called synchronously for every adjustment and failed sample
+	OnEvent func(Event)
+}
+
+// Controller samples a Source and resizes a cache between Config.Min and Config.Max
+type Controller struct {
+	mu    sync.Mutex
+	cache Resizable
+	src   Source
+	cfg   Config
+	now   func() time.Time
+}
+
+// NewController returns a controller for the given cache and metrics source
+func NewController(c Resizable, src Source, cfg Config) (*Controller, error) {
+	if c == nil || src == nil {
+		return nil, fmt.Errorf("cache and source are required")
+	}
+	if cfg.Min <= 0 || cfg.Max < cfg.Min {
+		return nil, fmt.Errorf("invalid bounds, must be 0 < min <= max")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.High == 0 {
+		cfg.High = 0.85
+	}
+	if cfg.Low == 0 {
+		cfg.Low = 0.6
+	}
+	if cfg.Low >= cfg.High {
+		return nil, fmt.Errorf("invalid thresholds, low must be less than high")
+	}
+	if cfg.ShrinkFactor == 0 {
+		cfg.ShrinkFactor = 0.75
+	}
+	if cfg.GrowFactor == 0 {
+		cfg.GrowFactor = 1.25
+	}
+	if cfg.ShrinkFactor <= 0 || cfg.ShrinkFactor >= 1 || cfg.GrowFactor <= 1 {
+		return nil, fmt.Errorf("invalid factors, must be 0 < shrink < 1 < grow")
+	}
+	return &Controller{
+		cache: c,
+		src:   src,
+		cfg:   cfg,
+		now:   time.Now,
+	}, nil
+}
+
+// Step takes one sample and adjusts the capacity, it reports the event and whether one was emitted
+func (ctl *Controller) Step() (Event, bool) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	old := ctl.cache.Capacity()
+	ev := Event{Time: ctl.now(), Old: old, New: old}
+	s, err := ctl.src.Sample()
+	if err == nil && s.Limit == 0 {
+		err = ErrNoLimit
+	}
+	if err != nil {
+		ev.Err = err
+		ctl.emit(ev)
+		return ev, true
+	}
+	ev.Used, ev.Limit = s.Used, s.Limit
+
+	target := ctl.target(old, float64(s.Used)/float64(s.Limit))
+	if target == old {
+		return ev, false
+	}
+	if err := ctl.cache.Resize(target); err != nil {
+		ev.Err = err
+		ctl.emit(ev)
+		return ev, true
+	}
+	ev.New = target
+	ctl.emit(ev)
+	return ev, true
+}
+
+// target returns the capacity for the given usage ratio, clamped to the configured bounds
+func (ctl *Controller) target(old int, ratio float64) int {
+	n := old
+	switch {
+	case ratio >= ctl.cfg.High:
+		n = int(math.Floor(float64(old) * ctl.cfg.ShrinkFactor))
+	case ratio <= ctl.cfg.Low:
+		n = int(math.Ceil(float64(old) * ctl.cfg.GrowFactor))
+	}
+	if n < ctl.cfg.Min {
+		n = ctl.cfg.Min
+	}
+	if n > ctl.cfg.Max {
+		n = ctl.cfg.Max
+	}
+	return n
+}
+
+func (ctl *Controller) emit(ev Event) {
+	if ctl.cfg.OnEvent != nil {
+		ctl.cfg.OnEvent(ev)
+	}
+}
+
+// Run calls Step every Config.Interval until ctx is done
+func (ctl *Controller) Run(ctx context.Context) {
+	ticker := time.NewTicker(ctl.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctl.Step()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
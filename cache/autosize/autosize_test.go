@@ -0,0 +1,133 @@
+package autosize
+
+import (
+	"cache/lru"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	samples []Sample
+	err     error
+}
+
+func (f *fakeSource) Sample() (Sample, error) {
+	if f.err != nil {
+		return Sample{}, f.err
+	}
+	s := f.samples[0]
+	if len(f.samples) > 1 {
+		f.samples = f.samples[1:]
+	}
+	return s, nil
+}
+
+func TestController(t *testing.T) {
+	t.Run("test shrink and grow within bounds", func(t *testing.T) {
+		cache, err := lru.NewCache[int, int](100, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			cache.Put(i, i)
+		}
+		src := &fakeSource{samples: []Sample{
+			{Used: 90, Limit: 100},
+			{Used: 95, Limit: 100},
+			{Used: 99, Limit: 100},
+			{Used: 70, Limit: 100},
+			{Used: 10, Limit: 100},
+			{Used: 10, Limit: 100},
+			{Used: 10, Limit: 100},
+		}}
+		var events []Event
+		ctl, err := NewController(cache, src, Config{Min: 50, Max: 120, OnEvent: func(e Event) {
+			events = append(events, e)
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []int{75, 56, 50, 50, 63, 79, 99}
+		for i, w := range want {
+			ctl.Step()
+			if got := cache.Capacity(); got != w {
+				t.Errorf("step %d: wanted capacity %d but got %d", i, w, got)
+			}
+		}
+		if len(events) != 6 {
+			t.Errorf("wanted 6 events but got %d", len(events))
+		}
+		for _, e := range events {
+			if e.Old == e.New {
+				t.Errorf("event without adjustment: %v", e)
+			}
+		}
+		if _, ok := cache.Get(0); ok {
+			t.Errorf("key %d should have been evicted on shrink", 0)
+		}
+		if _, ok := cache.Get(99); !ok {
+			t.Errorf("key %d should be present", 99)
+		}
+	})
+
+	t.Run("test sample error emits event", func(t *testing.T) {
+		cache, err := lru.NewCache[int, int](10, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantErr := errors.New("boom")
+		var got []Event
+		ctl, err := NewController(cache, &fakeSource{err: wantErr}, Config{Min: 1, Max: 10, OnEvent: func(e Event) {
+			got = append(got, e)
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctl.Step()
+		if len(got) != 1 || !errors.Is(got[0].Err, wantErr) {
+			t.Errorf("wanted one error event but got %v", got)
+		}
+		if cache.Capacity() != 10 {
+			t.Errorf("capacity should not change on error")
+		}
+	})
+
+	t.Run("test invalid config", func(t *testing.T) {
+		cache, _ := lru.NewCache[int, int](10, time.Minute)
+		if _, err := NewController(cache, &fakeSource{}, Config{Min: 10, Max: 5}); err == nil {
+			t.Errorf("expected error for min > max")
+		}
+		if _, err := NewController(cache, &fakeSource{}, Config{Min: 1, Max: 5, Low: 0.9, High: 0.5}); err == nil {
+			t.Errorf("expected error for low >= high")
+		}
+	})
+}
+
+func TestCgroupSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, val string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(val), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("memory.current", "1048576\n")
+	write("memory.max", "4194304\n")
+	s, err := CgroupSource{Dir: dir}.Sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Used != 1048576 || s.Limit != 4194304 {
+		t.Errorf("unexpected sample %+v", s)
+	}
+
+	write("memory.max", "max\n")
+	if _, err := (CgroupSource{Dir: dir}).Sample(); !errors.Is(err, ErrNoLimit) {
+		t.Errorf("wanted ErrNoLimit but got %v", err)
+	}
+	if _, err := Highest(CgroupSource{Dir: dir}, RuntimeSource{Limit: 1 << 40}).Sample(); err != nil {
+		t.Errorf("highest should fall back to the runtime source: %v", err)
+	}
+}
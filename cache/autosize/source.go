@@ -0,0 +1,132 @@
+package autosize
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+)
+
+// ErrNoLimit is returned by a Source when no memory limit is configured
+var ErrNoLimit = errors.New("no memory limit configured")
+
+// Sample is a memory usage reading against a limit, both in bytes
+type Sample struct {
+	Used  uint64
+	Limit uint64
+}
+
+// Source reports the current memory usage
+type Source interface {
+	Sample() (Sample, error)
+}
+
+// SourceFunc adapts a function to a Source
+type SourceFunc func() (Sample, error)
+
+// Sample calls f
+func (f SourceFunc) Sample() (Sample, error) {
+	return f()
+}
+
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// RuntimeSource samples the Go heap via runtime/metrics
+type RuntimeSource struct {
+	// Limit in bytes, when 0 the runtime soft memory limit (GOMEMLIMIT) is used
+	Limit uint64
+}
+
+// Sample returns the bytes occupied by heap objects against the limit
+func (s RuntimeSource) Sample() (Sample, error) {
+	samples := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 {
+		return Sample{}, fmt.Errorf("metric %s is not supported", heapObjectsMetric)
+	}
+	limit := s.Limit
+	if limit == 0 {
+		l := debug.SetMemoryLimit(-1)
+		if l <= 0 || l == math.MaxInt64 {
+			return Sample{}, ErrNoLimit
+		}
+		limit = uint64(l)
+	}
+	return Sample{Used: samples[0].Value.Uint64(), Limit: limit}, nil
+}
+
+// DefaultCgroupDir is where the cgroup v2 files of the current container are usually mounted
+const DefaultCgroupDir = "/sys/fs/cgroup"
+
+// CgroupSource samples memory.current and memory.max of a cgroup v2 directory
+type CgroupSource struct {
+	// Dir of the cgroup, defaults to DefaultCgroupDir
+	Dir string
+}
+
+// Sample returns the cgroup memory usage against its limit
+func (s CgroupSource) Sample() (Sample, error) {
+	dir := s.Dir
+	if dir == "" {
+		dir = DefaultCgroupDir
+	}
+	limit, err := readCgroupValue(filepath.Join(dir, "memory.max"))
+	if err != nil {
+		return Sample{}, err
+	}
+	current, err := readCgroupValue(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return Sample{}, err
+	}
+	return Sample{Used: current, Limit: limit}, nil
+}
+
+func readCgroupValue(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	b = bytes.TrimSpace(b)
+	if string(b) == "max" {
+		return 0, ErrNoLimit
+	}
+	v, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s failed with %v", path, err)
+	}
+	return v, nil
+}
+
+// Highest returns a Source reporting the sample with the highest used/limit ratio among srcs,
+// sources without a limit are ignored unless all of them fail
+func Highest(srcs ...Source) Source {
+	return SourceFunc(func() (Sample, error) {
+		var (
+			best    Sample
+			found   bool
+			lastErr error = ErrNoLimit
+		)
+		for _, src := range srcs {
+			s, err := src.Sample()
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if s.Limit == 0 {
+				continue
+			}
+			if !found || float64(s.Used)/float64(s.Limit) > float64(best.Used)/float64(best.Limit) {
+				best, found = s, true
+			}
+		}
+		if !found {
+			return Sample{}, lastErr
+		}
+		return best, nil
+	})
+}
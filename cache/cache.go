@@ -0,0 +1,16 @@
+// Package cache defines the interface shared by the cache implementations of this module.
+package cache
+
+// Cache is a concurrent safe key value cache holding a bounded number of items for a limited time
+type Cache[K comparable, V any] interface {
+	// Get returns the value and existence of a given key
+	Get(key K) (V, bool)
+	// Put puts the given key, value in cache, possibly evicting other items
+	Put(key K, val V)
+	// Delete removes key from the cache if it is present
+	Delete(key K)
+	// Len returns the number of items in the cache
+	Len() int
+	// Close releases the resources of the cache, it must not be used afterwards
+	Close() error
+}
@@ -0,0 +1,234 @@
+// Package cachetest provides a conformance suite for implementations of cache.Cache.
+package cachetest
+
+import (
+	"cache"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns a new empty cache holding at most capacity items, each for ttl
+type Factory func(capacity int, ttl time.Duration) (cache.Cache[string, string], error)
+
+// Options tunes which contracts Run checks
+type Options struct {
+	// Unbounded caches keep items beyond capacity, e.g. on disk, capacity and eviction order
+	// checks are skipped for them
+	Unbounded bool
+	// TTL used by the ttl check, defaults to a minute with a Clock and to a fraction of a second
+	// without one
+	TTL time.Duration
+	// Clock the factory makes its caches tell time with, the ttl check sleeps without one
+	Clock *Clock
+}
+
+// Clock is a manual clock for checking ttls without sleeping, the zero value starts at the unix epoch
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Now returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.now.IsZero() {
+		c.now = time.Unix(0, 0)
+	}
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.now.IsZero() {
+		c.now = time.Unix(0, 0)
+	}
+	c.now = c.now.Add(d)
+}
+
+// Run runs the conformance suite against caches returned by f
+func Run(t *testing.T, f Factory) {
+	RunWith(t, f, Options{})
+}
+
+// RunWith runs the conformance suite against caches returned by f with the given options
+func RunWith(t *testing.T, f Factory, opts Options) {
+	// advance lets the caches' time pass, by the clock or else by sleeping
+	advance := time.Sleep
+	if opts.Clock != nil {
+		advance = opts.Clock.Advance
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+		if opts.Clock == nil {
+			opts.TTL = 400 * time.Millisecond
+		}
+	}
+	newCache := func(t *testing.T, capacity int, ttl time.Duration) cache.Cache[string, string] {
+		t.Helper()
+		c, err := f(capacity, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := c.Close(); err != nil {
+				t.Errorf("close failed with %v", err)
+			}
+		})
+		return c
+	}
+
+	t.Run("get put", func(t *testing.T) {
+		c := newCache(t, 10, time.Minute)
+		if _, ok := c.Get("missing"); ok {
+			t.Errorf("key \"%s\" should not be present", "missing")
+		}
+		for i := 0; i < 10; i++ {
+			c.Put(key(i), val(i))
+		}
+		for i := 0; i < 10; i++ {
+			if v, ok := c.Get(key(i)); !ok || v != val(i) {
+				t.Errorf("wanted %s but got %q, %v", val(i), v, ok)
+			}
+		}
+		if n := c.Len(); n != 10 {
+			t.Errorf("wanted len 10 but got %d", n)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		c := newCache(t, 10, time.Minute)
+		c.Put("foo", "bar")
+		c.Put("foo", "baz")
+		if v, ok := c.Get("foo"); !ok || v != "baz" {
+			t.Errorf("wanted baz but got %q, %v", v, ok)
+		}
+		if n := c.Len(); n != 1 {
+			t.Errorf("wanted len 1 but got %d", n)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		c := newCache(t, 10, time.Minute)
+		c.Put("foo", "bar")
+		c.Put("john", "doe")
+		c.Delete("foo")
+		c.Delete("missing")
+		if _, ok := c.Get("foo"); ok {
+			t.Errorf("key \"%s\" should not be present", "foo")
+		}
+		if _, ok := c.Get("john"); !ok {
+			t.Errorf("key \"%s\" should be present", "john")
+		}
+		if n := c.Len(); n != 1 {
+			t.Errorf("wanted len 1 but got %d", n)
+		}
+	})
+
+	t.Run("capacity", func(t *testing.T) {
+		if opts.Unbounded {
+			t.Skip("cache is unbounded")
+		}
+		c := newCache(t, 5, time.Minute)
+		for i := 0; i < 15; i++ {
+			c.Put(key(i), val(i))
+		}
+		if n := c.Len(); n != 5 {
+			t.Errorf("wanted len 5 but got %d", n)
+		}
+		for i := 0; i < 10; i++ {
+			if _, ok := c.Get(key(i)); ok {
+				t.Errorf("key \"%s\" should have been evicted", key(i))
+			}
+		}
+	})
+
+	t.Run("eviction order", func(t *testing.T) {
+		if opts.Unbounded {
+			t.Skip("cache is unbounded")
+		}
+		c := newCache(t, 3, time.Minute)
+		for i := 0; i < 3; i++ {
+			c.Put(key(i), val(i))
+		}
+		c.Get(key(0)) // key 1 is now the least recently used
+		c.Put(key(3), val(3))
+		if _, ok := c.Get(key(1)); ok {
+			t.Errorf("key \"%s\" should have been evicted", key(1))
+		}
+		c.Put(key(0), val(0)) // key 2 is now the least recently used
+		c.Put(key(4), val(4))
+		if _, ok := c.Get(key(2)); ok {
+			t.Errorf("key \"%s\" should have been evicted", key(2))
+		}
+		for _, i := range []int{0, 3, 4} {
+			if _, ok := c.Get(key(i)); !ok {
+				t.Errorf("key \"%s\" should be present", key(i))
+			}
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := newCache(t, 10, opts.TTL)
+		for i := 0; i < 5; i++ {
+			c.Put(key(i), val(i))
+		}
+		advance(opts.TTL / 2)
+		c.Get(key(0)) // access refreshes the ttl
+		advance(opts.TTL/2 + opts.TTL/4)
+		if _, ok := c.Get(key(0)); !ok {
+			t.Errorf("key \"%s\" should be present", key(0))
+		}
+		for i := 1; i < 5; i++ {
+			if _, ok := c.Get(key(i)); ok {
+				t.Errorf("key \"%s\" should have expired", key(i))
+			}
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		c := newCache(t, 100, time.Minute)
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					k := key((g*500 + i) % 150)
+					switch i % 4 {
+					case 0, 1:
+						c.Put(k, k)
+					case 2:
+						if v, ok := c.Get(k); ok && v != k {
+							t.Errorf("wanted %s but got %s", k, v)
+						}
+					case 3:
+						if i%8 == 3 {
+							c.Delete(k)
+						} else {
+							c.Len()
+						}
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		if !opts.Unbounded {
+			if n := c.Len(); n > 100 {
+				t.Errorf("len %d exceeds capacity 100", n)
+			}
+		}
+	})
+}
+
+func key(i int) string {
+	return fmt.Sprintf("key%d", i)
+}
+
+func val(i int) string {
+	return fmt.Sprintf("val%d", i)
+}
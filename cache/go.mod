@@ -1,5 +1,5 @@
 module cache
 
-go 1.19
+go 1.21
 
 require golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e
@@ -0,0 +1,477 @@
+// Package httpcache provides an http.Handler middleware caching GET and HEAD responses.
+//
+// It behaves as a shared cache in front of the wrapped handler: responses marked no-store or
+// private, setting cookies, or answering requests with an Authorization header (unless marked
+// public or s-maxage) are never stored. The ttl of the backing cache should exceed the longest
+// freshness lifetime expected, entries dropped by it are simply fetched again.
+package httpcache
+
+import (
+	"bytes"
+	"cache"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HeaderCache is the response header reporting how a response was served
+const HeaderCache = "X-Cache"
+
+// Values of HeaderCache
+const (
+	Hit         = "HIT"
+	Miss        = "MISS"
+	Revalidated = "REVALIDATED"
+	Bypass      = "BYPASS"
+)
+
+// DefaultMaxBodySize is the largest response body stored by default
+const DefaultMaxBodySize int64 = 1 << 20
+
+// Entry is a cached response, or the list of request headers a response varies on
+type Entry struct {
+	// set on the entry stored under the method and url when the response varies
+	vary []string
+	gen  uint64
+
+	status     int
+	header     http.Header
+	body       []byte
+	storedAt   time.Time
+	initialAge time.Duration
+	freshFor   time.Duration
+}
+
+// Handler serves responses of next from a cache when they are fresh or could be revalidated
+type Handler struct {
+	next  http.Handler
+	cache cache.Cache[string, *Entry]
+	gen   uint64
+
+	// MaxBodySize is the largest response body stored, larger ones are passed through
+	MaxBodySize int64
+
+	now func() time.Time
+}
+
+// New returns a Handler caching responses of next in c, an lru.Cache for instance
+func New(next http.Handler, c cache.Cache[string, *Entry]) *Handler {
+	return &Handler{
+		next:        next,
+		cache:       c,
+		MaxBodySize: DefaultMaxBodySize,
+		now:         time.Now,
+	}
+}
+
+// Middleware returns a function wrapping handlers with New using c
+func Middleware(c cache.Cache[string, *Entry]) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return New(next, c)
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		// unsafe methods invalidate what is cached for the url
+		h.cache.Delete(primaryKey(http.MethodGet, r))
+		h.cache.Delete(primaryKey(http.MethodHead, r))
+		w.Header().Set(HeaderCache, Bypass)
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	reqCC := parseCacheControl(r.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		w.Header().Set(HeaderCache, Bypass)
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	entry, ok := h.lookup(r.Method, r)
+	if !ok && r.Method == http.MethodHead {
+		entry, ok = h.lookup(http.MethodGet, r)
+	}
+	if !ok {
+		h.fetch(w, r)
+		return
+	}
+
+	_, noCache := reqCC["no-cache"]
+	if age := h.age(entry); !noCache && age < entry.freshFor {
+		h.serve(w, r, entry, Hit)
+		return
+	}
+	if entry.header.Get("ETag") == "" && entry.header.Get("Last-Modified") == "" {
+		h.fetch(w, r)
+		return
+	}
+	h.revalidate(w, r, entry)
+}
+
+func primaryKey(method string, r *http.Request) string {
+	return method + " " + r.Host + r.URL.RequestURI()
+}
+
+func secondaryKey(primary string, gen uint64, vary []string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(primary)
+	b.WriteString("\n")
+	b.WriteString(strconv.FormatUint(gen, 10))
+	for _, name := range vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+func (h *Handler) lookup(method string, r *http.Request) (*Entry, bool) {
+	primary := primaryKey(method, r)
+	entry, ok := h.cache.Get(primary)
+	if !ok || entry.vary == nil {
+		return entry, ok
+	}
+	return h.cache.Get(secondaryKey(primary, entry.gen, entry.vary, r))
+}
+
+func (h *Handler) store(r *http.Request, entry *Entry, vary []string) {
+	primary := primaryKey(r.Method, r)
+	if len(vary) == 0 {
+		h.cache.Put(primary, entry)
+		return
+	}
+	idx, ok := h.cache.Get(primary)
+	if !ok || !equal(idx.vary, vary) {
+		idx = &Entry{vary: vary, gen: atomic.AddUint64(&h.gen, 1)}
+		h.cache.Put(primary, idx)
+	}
+	h.cache.Put(secondaryKey(primary, idx.gen, vary, r), entry)
+}
+
+// age returns the current age of entry
+func (h *Handler) age(entry *Entry) time.Duration {
+	return entry.initialAge + h.now().Sub(entry.storedAt)
+}
+
+// fetch serves a response from next, storing it when it is cacheable
+func (h *Handler) fetch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HeaderCache, Miss)
+	rec := &recorder{w: w, limit: h.MaxBodySize}
+	h.next.ServeHTTP(rec, r)
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	h.maybeStore(r, rec)
+}
+
+// revalidate asks next whether entry is still valid using its validators
+func (h *Handler) revalidate(w http.ResponseWriter, r *http.Request, entry *Entry) {
+	cr := r.Clone(r.Context())
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		cr.Header.Del(name)
+	}
+	if etag := entry.header.Get("ETag"); etag != "" {
+		cr.Header.Set("If-None-Match", etag)
+	}
+	if lm := entry.header.Get("Last-Modified"); lm != "" {
+		cr.Header.Set("If-Modified-Since", lm)
+	}
+	rec := &recorder{header: make(http.Header), limit: h.MaxBodySize}
+	h.next.ServeHTTP(rec, cr)
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	if rec.status == http.StatusNotModified {
+		updated := *entry
+		updated.header = entry.header.Clone()
+		for name, values := range rec.snapshot {
+			if name == HeaderCache || name == "Content-Length" {
+				continue
+			}
+			updated.header[name] = values
+		}
+		updated.storedAt = h.now()
+		updated.initialAge = ageHeader(rec.snapshot)
+		updated.freshFor, _ = freshness(parseCacheControl(updated.header), updated.header, updated.storedAt)
+		h.store(r, &updated, varyNames(updated.header))
+		h.serve(w, r, &updated, Revalidated)
+		return
+	}
+
+	copyHeader(w.Header(), rec.snapshot)
+	w.Header().Set(HeaderCache, Miss)
+	w.WriteHeader(rec.status)
+	if r.Method != http.MethodHead {
+		w.Write(rec.body.Bytes())
+	}
+	h.maybeStore(r, rec)
+}
+
+// maybeStore stores the recorded response when it is cacheable
+func (h *Handler) maybeStore(r *http.Request, rec *recorder) {
+	if rec.overflow || !cacheableStatus(rec.status) {
+		return
+	}
+	header := rec.snapshot
+	cc := parseCacheControl(header)
+	if _, ok := cc["no-store"]; ok {
+		return
+	}
+	if _, ok := cc["private"]; ok {
+		return
+	}
+	if len(header.Values("Set-Cookie")) > 0 {
+		return
+	}
+	if r.Header.Get("Authorization") != "" {
+		_, public := cc["public"]
+		_, smaxage := cc["s-maxage"]
+		if !public && !smaxage {
+			return
+		}
+	}
+	vary := varyNames(header)
+	for _, name := range vary {
+		if name == "*" {
+			return
+		}
+	}
+
+	now := h.now()
+	freshFor, explicit := freshness(cc, header, now)
+	if !explicit && header.Get("ETag") == "" && header.Get("Last-Modified") == "" {
+		return
+	}
+	stored := header.Clone()
+	stored.Del(HeaderCache)
+	stored.Del("Age")
+	for _, name := range hopHeaders {
+		stored.Del(name)
+	}
+	var body []byte
+	if r.Method != http.MethodHead {
+		body = append([]byte(nil), rec.body.Bytes()...)
+	}
+	h.store(r, &Entry{
+		status:     rec.status,
+		header:     stored,
+		body:       body,
+		storedAt:   now,
+		initialAge: ageHeader(header),
+		freshFor:   freshFor,
+	}, vary)
+}
+
+// serve writes entry to w, answering the client's own conditional request with 304 when it matches
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, entry *Entry, how string) {
+	copyHeader(w.Header(), entry.header)
+	w.Header().Set("Age", strconv.FormatInt(int64(h.age(entry)/time.Second), 10))
+	w.Header().Set(HeaderCache, how)
+	if notModified(r, entry.header) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(entry.status)
+	if r.Method != http.MethodHead {
+		w.Write(entry.body)
+	}
+}
+
+// notModified reports whether the conditional headers of r match header
+func notModified(r *http.Request, header http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lm.After(ims)
+}
+
+// freshness returns the freshness lifetime of a response and whether it was given explicitly
+func freshness(cc map[string]string, header http.Header, now time.Time) (time.Duration, bool) {
+	if _, ok := cc["no-cache"]; ok {
+		return 0, true
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			secs, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || secs < 0 {
+				return 0, true
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// invalid dates, like "0", mean already expired
+			return 0, true
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		if d := expires.Sub(date); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, val, _ := strings.Cut(directive, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(val), `"`)
+		}
+	}
+	return cc
+}
+
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ageHeader(header http.Header) time.Duration {
+	secs, err := strconv.ParseInt(header.Get("Age"), 10, 64)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		dst[name] = append([]string(nil), values...)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// recorder captures a response of next, writing it through to w unless w is nil
+type recorder struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	status      int
+	snapshot    http.Header
+	wroteHeader bool
+	body        bytes.Buffer
+	limit       int64
+	overflow    bool
+}
+
+func (rec *recorder) Header() http.Header {
+	if rec.w != nil {
+		return rec.w.Header()
+	}
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = status
+	rec.snapshot = rec.Header().Clone()
+	if rec.w != nil {
+		rec.w.WriteHeader(status)
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.w == nil {
+		// nothing to write through to, keep the whole body for the caller
+		rec.overflow = rec.overflow || int64(rec.body.Len()+len(b)) > rec.limit
+		return rec.body.Write(b)
+	}
+	if !rec.overflow {
+		if int64(rec.body.Len()+len(b)) > rec.limit {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.w.Write(b)
+}
+
+// Flush flushes the underlying writer when it supports it
+func (rec *recorder) Flush() {
+	if f, ok := rec.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
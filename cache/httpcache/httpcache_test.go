@@ -0,0 +1,207 @@
+package httpcache
+
+import (
+	"cache/lru"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type origin struct {
+	calls   int
+	handler func(w http.ResponseWriter, r *http.Request)
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.calls++
+	o.handler(w, r)
+}
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newHandler(t *testing.T, o *origin) (*Handler, *clock) {
+	t.Helper()
+	c, err := lru.NewCache[string, *Entry](100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	clk := &clock{t: time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)}
+	h := New(o, c)
+	h.now = clk.now
+	return h, clk
+}
+
+func do(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("test max-age hit with age", func(t *testing.T) {
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			fmt.Fprint(w, "hello")
+		}}
+		h, clk := newHandler(t, o)
+		if rec := do(h, http.MethodGet, "/foo", nil); rec.Header().Get(HeaderCache) != Miss || rec.Body.String() != "hello" {
+			t.Errorf("wanted a miss but got %s %q", rec.Header().Get(HeaderCache), rec.Body.String())
+		}
+		clk.t = clk.t.Add(10 * time.Second)
+		rec := do(h, http.MethodGet, "/foo", nil)
+		if rec.Header().Get(HeaderCache) != Hit || rec.Body.String() != "hello" {
+			t.Errorf("wanted a hit but got %s %q", rec.Header().Get(HeaderCache), rec.Body.String())
+		}
+		if age := rec.Header().Get("Age"); age != "10" {
+			t.Errorf("wanted age 10 but got %s", age)
+		}
+		if rec := do(h, http.MethodHead, "/foo", nil); rec.Header().Get(HeaderCache) != Hit || rec.Body.Len() != 0 {
+			t.Errorf("wanted a bodyless hit for HEAD but got %s %q", rec.Header().Get(HeaderCache), rec.Body.String())
+		}
+		clk.t = clk.t.Add(time.Minute)
+		if rec := do(h, http.MethodGet, "/foo", nil); rec.Header().Get(HeaderCache) != Miss {
+			t.Errorf("wanted a miss after expiry but got %s", rec.Header().Get(HeaderCache))
+		}
+		if o.calls != 2 {
+			t.Errorf("wanted 2 origin calls but got %d", o.calls)
+		}
+	})
+
+	t.Run("test s-maxage and expires", func(t *testing.T) {
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/shared" {
+				w.Header().Set("Cache-Control", "max-age=0, s-maxage=30")
+			} else {
+				w.Header().Set("Date", "Mon, 01 Aug 2022 00:00:00 GMT")
+				w.Header().Set("Expires", "Mon, 01 Aug 2022 00:00:20 GMT")
+			}
+		}}
+		h, clk := newHandler(t, o)
+		do(h, http.MethodGet, "/shared", nil)
+		do(h, http.MethodGet, "/expires", nil)
+		clk.t = clk.t.Add(15 * time.Second)
+		for _, path := range []string{"/shared", "/expires"} {
+			if rec := do(h, http.MethodGet, path, nil); rec.Header().Get(HeaderCache) != Hit {
+				t.Errorf("%s: wanted a hit but got %s", path, rec.Header().Get(HeaderCache))
+			}
+		}
+		clk.t = clk.t.Add(10 * time.Second)
+		if rec := do(h, http.MethodGet, "/expires", nil); rec.Header().Get(HeaderCache) != Miss {
+			t.Errorf("wanted a miss after expires but got %s", rec.Header().Get(HeaderCache))
+		}
+	})
+
+	t.Run("test no-store and private are not cached", func(t *testing.T) {
+		for _, cc := range []string{"no-store", "private, max-age=60"} {
+			o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", cc)
+			}}
+			h, _ := newHandler(t, o)
+			do(h, http.MethodGet, "/foo", nil)
+			if rec := do(h, http.MethodGet, "/foo", nil); rec.Header().Get(HeaderCache) != Miss {
+				t.Errorf("%s: wanted a miss but got %s", cc, rec.Header().Get(HeaderCache))
+			}
+		}
+	})
+
+	t.Run("test revalidation", func(t *testing.T) {
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=5")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fmt.Fprint(w, "hello")
+		}}
+		h, clk := newHandler(t, o)
+		do(h, http.MethodGet, "/foo", nil)
+		clk.t = clk.t.Add(10 * time.Second)
+		rec := do(h, http.MethodGet, "/foo", nil)
+		if rec.Header().Get(HeaderCache) != Revalidated || rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+			t.Errorf("wanted a revalidated 200 but got %s %d %q", rec.Header().Get(HeaderCache), rec.Code, rec.Body.String())
+		}
+		if age := rec.Header().Get("Age"); age != "0" {
+			t.Errorf("wanted age 0 after revalidation but got %s", age)
+		}
+		if rec := do(h, http.MethodGet, "/foo", nil); rec.Header().Get(HeaderCache) != Hit {
+			t.Errorf("wanted a hit after revalidation but got %s", rec.Header().Get(HeaderCache))
+		}
+		if o.calls != 2 {
+			t.Errorf("wanted 2 origin calls but got %d", o.calls)
+		}
+	})
+
+	t.Run("test client conditional request", func(t *testing.T) {
+		lastModified := "Mon, 01 Aug 2022 00:00:00 GMT"
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `W/"v1"`)
+			w.Header().Set("Last-Modified", lastModified)
+			fmt.Fprint(w, "hello")
+		}}
+		h, _ := newHandler(t, o)
+		do(h, http.MethodGet, "/foo", nil)
+		rec := do(h, http.MethodGet, "/foo", http.Header{"If-None-Match": {`"v1"`}})
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("wanted 304 but got %d %q", rec.Code, rec.Body.String())
+		}
+		rec = do(h, http.MethodGet, "/foo", http.Header{"If-Modified-Since": {lastModified}})
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("wanted 304 but got %d", rec.Code)
+		}
+		rec = do(h, http.MethodGet, "/foo", http.Header{"If-None-Match": {`"v2"`}})
+		if rec.Code != http.StatusOK {
+			t.Errorf("wanted 200 but got %d", rec.Code)
+		}
+	})
+
+	t.Run("test vary", func(t *testing.T) {
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			fmt.Fprint(w, r.Header.Get("Accept-Language"))
+		}}
+		h, _ := newHandler(t, o)
+		en := http.Header{"Accept-Language": {"en"}}
+		de := http.Header{"Accept-Language": {"de"}}
+		do(h, http.MethodGet, "/foo", en)
+		do(h, http.MethodGet, "/foo", de)
+		if rec := do(h, http.MethodGet, "/foo", en); rec.Header().Get(HeaderCache) != Hit || rec.Body.String() != "en" {
+			t.Errorf("wanted a hit for en but got %s %q", rec.Header().Get(HeaderCache), rec.Body.String())
+		}
+		if rec := do(h, http.MethodGet, "/foo", de); rec.Header().Get(HeaderCache) != Hit || rec.Body.String() != "de" {
+			t.Errorf("wanted a hit for de but got %s %q", rec.Header().Get(HeaderCache), rec.Body.String())
+		}
+		if o.calls != 2 {
+			t.Errorf("wanted 2 origin calls but got %d", o.calls)
+		}
+	})
+
+	t.Run("test unsafe method invalidates", func(t *testing.T) {
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}}
+		h, _ := newHandler(t, o)
+		do(h, http.MethodGet, "/foo", nil)
+		if rec := do(h, http.MethodPost, "/foo", nil); rec.Header().Get(HeaderCache) != Bypass {
+			t.Errorf("wanted a bypass but got %s", rec.Header().Get(HeaderCache))
+		}
+		if rec := do(h, http.MethodGet, "/foo", nil); rec.Header().Get(HeaderCache) != Miss {
+			t.Errorf("wanted a miss after post but got %s", rec.Header().Get(HeaderCache))
+		}
+	})
+}
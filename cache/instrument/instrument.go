@@ -0,0 +1,116 @@
+// Package instrument provides lru.Hooks adapters for logging and tracing.
+package instrument
+
+import (
+	"cache/lru"
+	"context"
+	"log/slog"
+
+	"golang.org/x/exp/constraints"
+)
+
+type slogHooks[K constraints.Ordered] struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// Slog returns hooks logging every operation to logger at level, failed ones at slog.LevelError
+func Slog[K constraints.Ordered](logger *slog.Logger, level slog.Level) lru.Hooks[K] {
+	return slogHooks[K]{logger: logger, level: level}
+}
+
+func (h slogHooks[K]) Before(ctx context.Context, op lru.Op, key K) context.Context {
+	return ctx
+}
+
+func (h slogHooks[K]) After(ctx context.Context, op lru.Op, key K, out lru.Outcome) {
+	level := h.level
+	if out.Err != nil {
+		level = slog.LevelError
+	}
+	if !h.logger.Enabled(ctx, level) {
+		return
+	}
+	var attrs []slog.Attr
+	switch op {
+	case lru.OpClean:
+		attrs = append(attrs, slog.Int("removed", out.Removed))
+	case lru.OpGet:
+		attrs = append(attrs, slog.Any("key", key), slog.Bool("hit", out.Hit))
+	default:
+		attrs = append(attrs, slog.Any("key", key))
+	}
+	attrs = append(attrs, slog.Duration("duration", out.Duration))
+	if out.Err != nil {
+		attrs = append(attrs, slog.Any("error", out.Err))
+	}
+	h.logger.LogAttrs(ctx, level, "cache "+op.String(), attrs...)
+}
+
+// Span is the part of a tracing span the hooks use
+type Span interface {
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans, adapt your tracing system's tracer to it
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type spanKey struct{}
+
+type tracingHooks[K constraints.Ordered] struct {
+	tracer Tracer
+}
+
+// Tracing returns hooks wrapping every operation in a span named "cache.<op>"
+func Tracing[K constraints.Ordered](tracer Tracer) lru.Hooks[K] {
+	return tracingHooks[K]{tracer: tracer}
+}
+
+func (h tracingHooks[K]) Before(ctx context.Context, op lru.Op, key K) context.Context {
+	ctx, span := h.tracer.Start(ctx, "cache."+op.String())
+	if op != lru.OpClean {
+		span.SetAttribute("cache.key", key)
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (h tracingHooks[K]) After(ctx context.Context, op lru.Op, key K, out lru.Outcome) {
+	span, ok := ctx.Value(spanKey{}).(Span)
+	if !ok {
+		return
+	}
+	switch op {
+	case lru.OpGet:
+		span.SetAttribute("cache.hit", out.Hit)
+	case lru.OpClean:
+		span.SetAttribute("cache.removed", out.Removed)
+	}
+	if out.Err != nil {
+		span.RecordError(out.Err)
+	}
+	span.End()
+}
+
+type multiHooks[K constraints.Ordered] []lru.Hooks[K]
+
+// Multi returns hooks calling each of hooks in order
+func Multi[K constraints.Ordered](hooks ...lru.Hooks[K]) lru.Hooks[K] {
+	return multiHooks[K](hooks)
+}
+
+func (m multiHooks[K]) Before(ctx context.Context, op lru.Op, key K) context.Context {
+	for _, h := range m {
+		ctx = h.Before(ctx, op, key)
+	}
+	return ctx
+}
+
+func (m multiHooks[K]) After(ctx context.Context, op lru.Op, key K, out lru.Outcome) {
+	for i := len(m) - 1; i >= 0; i-- {
+		m[i].After(ctx, op, key, out)
+	}
+}
@@ -0,0 +1,131 @@
+package instrument
+
+import (
+	"bytes"
+	"cache/lru"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSpan struct {
+	name   string
+	parent *fakeSpan
+	attrs  map[string]any
+	err    error
+	ended  bool
+}
+
+func (s *fakeSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *fakeSpan) RecordError(err error)              { s.err = err }
+func (s *fakeSpan) End()                               { s.ended = true }
+
+type parentKey struct{}
+
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &fakeSpan{name: name, attrs: make(map[string]any)}
+	s.parent, _ = ctx.Value(parentKey{}).(*fakeSpan)
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, parentKey{}, s), s
+}
+
+func TestTracing(t *testing.T) {
+	c, err := lru.NewCache[string, string](1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	tracer := &fakeTracer{}
+	c.SetHooks(Tracing[string](tracer))
+
+	c.Put("foo", "bar")
+	c.Get("foo")
+	c.Put("john", "doe") // evicts foo
+	loadErr := errors.New("not found")
+	_, err = c.GetOrLoad(context.Background(), "city", func(ctx context.Context, key string) (string, error) {
+		return "", loadErr
+	})
+	if !errors.Is(err, loadErr) {
+		t.Errorf("wanted load error but got %v", err)
+	}
+
+	want := []string{"cache.put", "cache.get", "cache.put", "cache.evict", "cache.get", "cache.load"}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("wanted %d spans but got %d", len(want), len(tracer.spans))
+	}
+	for i, s := range tracer.spans {
+		if s.name != want[i] {
+			t.Errorf("span %d: wanted %s but got %s", i, want[i], s.name)
+		}
+		if !s.ended {
+			t.Errorf("span %s was not ended", s.name)
+		}
+	}
+	if hit := tracer.spans[1].attrs["cache.hit"]; hit != true {
+		t.Errorf("wanted a hit but got %v", hit)
+	}
+	if key := tracer.spans[3].attrs["cache.key"]; key != "foo" {
+		t.Errorf("wanted foo evicted but got %v", key)
+	}
+	if tracer.spans[5].err != loadErr {
+		t.Errorf("load span should record the error")
+	}
+
+	// evictions made by a put are traced within the put
+	tracer.spans = nil
+	if _, err := c.GetOrLoad(context.Background(), "city", func(ctx context.Context, key string) (string, error) {
+		return "paris", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"cache.get", "cache.load", "cache.put", "cache.evict"}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("wanted %d spans but got %d", len(want), len(tracer.spans))
+	}
+	if evict := tracer.spans[3]; evict.name != "cache.evict" || evict.parent != tracer.spans[2] {
+		t.Errorf("eviction span %s should be a child of the put span", evict.name)
+	}
+}
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c, err := lru.NewCache[string, string](10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetHooks(Multi(Slog[string](logger, slog.LevelDebug)))
+
+	val, err := c.GetOrLoad(context.Background(), "foo", func(ctx context.Context, key string) (string, error) {
+		return "bar", nil
+	})
+	if err != nil || val != "bar" {
+		t.Fatalf("wanted bar but got %q, %v", val, err)
+	}
+	if val, ok := c.Get("foo"); !ok || val != "bar" {
+		t.Errorf("loaded value should be cached")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{`msg="cache get" key=foo hit=false`, `msg="cache load" key=foo`, `msg="cache put" key=foo`, `msg="cache get" key=foo hit=true`}
+	if len(lines) != len(want) {
+		t.Fatalf("wanted %d log lines but got %d:\n%s", len(want), len(lines), buf.String())
+	}
+	for i, w := range want {
+		if !strings.Contains(lines[i], w) {
+			t.Errorf("line %d: wanted %s in %s", i, w, lines[i])
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package lru
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Op is an instrumented cache operation
+type Op int
+
+const (
+	OpGet Op = iota
+	OpPut
+	OpEvict
+	OpClean
+	OpLoad
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "get"
+	case OpPut:
+		return "put"
+	case OpEvict:
+		return "evict"
+	case OpClean:
+		return "clean"
+	case OpLoad:
+		return "load"
+	}
+	return "unknown"
+}
+
+// Outcome describes how an operation went
+type Outcome struct {
+	Duration time.Duration
+	// Hit is set for gets that found the key
+	Hit bool
+	// Removed is the number of expired items removed by a clean pass
+	Removed int
+	// Err is set for failed loads and clean passes cut short
+	Err error
+}
+
+// Hooks observe cache operations. Before is called when an operation starts and returns the
+// context passed to After, so hooks can carry state such as a span between the two. Evictions
+// get the context of the put making room, operations without a context of their own get
+// context.Background(). Hooks must not use the cache.
+type Hooks[K constraints.Ordered] interface {
+	Before(ctx context.Context, op Op, key K) context.Context
+	After(ctx context.Context, op Op, key K, out Outcome)
+}
+
+type hooksBox[K constraints.Ordered] struct {
+	h Hooks[K]
+}
+
+// SetHooks sets the hooks observing the cache operations, nil removes them
+func (c *Cache[K, T]) SetHooks(h Hooks[K]) {
+	if h == nil {
+		c.hooks.Store(nil)
+		return
+	}
+	c.hooks.Store(&hooksBox[K]{h})
+}
+
+func (c *Cache[K, T]) loadHooks() Hooks[K] {
+	if b := c.hooks.Load(); b != nil {
+		return b.h
+	}
+	return nil
+}
+
+// observe runs fn between the Before and After hooks of op
+func (c *Cache[K, T]) observe(ctx context.Context, op Op, key K, fn func(ctx context.Context) Outcome) {
+	h := c.loadHooks()
+	if h == nil {
+		fn(ctx)
+		return
+	}
+	ctx = h.Before(ctx, op, key)
+	start := time.Now()
+	out := fn(ctx)
+	out.Duration = time.Since(start)
+	h.After(ctx, op, key, out)
+}
+
+// GetOrLoad returns the value of key, calling load and putting its result in cache on a miss.
+// Concurrent loads of the same key are not coalesced.
+func (c *Cache[K, T]) GetOrLoad(ctx context.Context, key K, load func(ctx context.Context, key K) (T, error)) (T, error) {
+	if val, ok := c.get(ctx, key); ok {
+		return val, nil
+	}
+	var (
+		val T
+		err error
+	)
+	c.observe(ctx, OpLoad, key, func(ctx context.Context) Outcome {
+		val, err = load(ctx, key)
+		return Outcome{Err: err}
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	c.put(ctx, key, val)
+	return val, nil
+}
@@ -1,10 +1,12 @@
 package lru
 
 import (
+	"cache"
 	"container/list"
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/exp/constraints"
@@ -26,8 +28,16 @@ type Cache[K constraints.Ordered, V any] struct {
 	items    *list.List
 	itemIdx  map[K]*list.Element
 	ttl      time.Duration
+	now      func() time.Time
+	onEvict  EvictFunc[K, V]
+	hooks    atomic.Pointer[hooksBox[K]]
 }
 
+var _ cache.Cache[string, string] = (*Cache[string, string])(nil)
+
+// EvictFunc is called with an item removed to make room for others, expiresAt is when its ttl runs out
+type EvictFunc[K constraints.Ordered, V any] func(key K, val V, expiresAt time.Time)
+
 // NewCache returns a lru cache with given cache size and cache item ttl
 func NewCache[K constraints.Ordered, V any](cacheSize int, cacheItemTtl time.Duration) (*Cache[K, V], error) {
 	if cacheSize <= 0 {
@@ -42,6 +52,7 @@ func NewCache[K constraints.Ordered, V any](cacheSize int, cacheItemTtl time.Dur
 		items:    list.New(),
 		itemIdx:  make(map[K]*list.Element),
 		ttl:      cacheItemTtl,
+		now:      time.Now,
 
 		cleanCtx:    ctx,
 		cleanCancel: cancel,
@@ -57,16 +68,10 @@ func clean[K constraints.Ordered, V any](c *Cache[K, V]) {
 		ctx, cancel := context.WithTimeout(c.cleanCtx, cleanInterval)
 		defer cancel()
 
-		c.mu.Lock()
-		defer c.mu.Unlock()
-
-		for e := c.items.Back(); e != nil && ctx.Err() == nil; e = e.Prev() {
-			item := e.Value.(*node[K, V])
-			if time.Since(item.usedAt) >= c.ttl {
-				c.items.Remove(e)
-				delete(c.itemIdx, item.key)
-			}
-		}
+		var zero K
+		c.observe(ctx, OpClean, zero, func(ctx context.Context) Outcome {
+			return Outcome{Removed: c.removeExpired(ctx), Err: ctx.Err()}
+		})
 	}
 
 	ticker := time.NewTicker(cleanInterval)
@@ -80,6 +85,26 @@ func clean[K constraints.Ordered, V any](c *Cache[K, V]) {
 	}
 }
 
+// removeExpired removes the items whose ttl ran out until ctx is done and returns how many
+func (c *Cache[K, V]) removeExpired(ctx context.Context) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var removed int
+	for e := c.items.Back(); e != nil && ctx.Err() == nil; {
+		// Remove clears the links of e, take the next one first
+		prev := e.Prev()
+		item := e.Value.(*node[K, V])
+		if c.now().Sub(item.usedAt) >= c.ttl {
+			c.items.Remove(e)
+			delete(c.itemIdx, item.key)
+			removed++
+		}
+		e = prev
+	}
+	return removed
+}
+
 func exists[K constraints.Ordered, V any](key K, c *Cache[K, V]) (*list.Element, bool) {
 	i, ok := c.itemIdx[key]
 	return i, ok
@@ -108,18 +133,33 @@ func (c *Cache[K, T]) ResumeCleaning() {
 
 // Get returns the value and existence of a given key k
 func (c *Cache[K, T]) Get(key K) (T, bool) {
+	return c.get(context.Background(), key)
+}
+
+func (c *Cache[K, T]) get(ctx context.Context, key K) (val T, ok bool) {
+	if c.loadHooks() == nil {
+		return c.lookup(key)
+	}
+	c.observe(ctx, OpGet, key, func(context.Context) Outcome {
+		val, ok = c.lookup(key)
+		return Outcome{Hit: ok}
+	})
+	return val, ok
+}
+
+func (c *Cache[K, T]) lookup(key K) (T, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if el, doesexist := exists(key, c); doesexist {
 		item := el.Value.(*node[K, T])
 		c.items.Remove(el)
 		delete(c.itemIdx, item.key)
-		if time.Since(item.usedAt) >= c.ttl && c.cleanCtx.Err() == nil {
+		if c.now().Sub(item.usedAt) >= c.ttl && c.cleanCtx.Err() == nil {
 			var zero T
 			return zero, false
 		}
 		// update the item's used at to now and add it to the front of items
-		item.usedAt = time.Now()
+		item.usedAt = c.now()
 		c.itemIdx[item.key] = c.items.PushFront(item)
 
 		return item.val, true
@@ -130,27 +170,134 @@ func (c *Cache[K, T]) Get(key K) (T, bool) {
 
 // Put puts the given k, v in cache
 func (c *Cache[K, T]) Put(key K, val T) {
+	c.put(context.Background(), key, val)
+}
+
+func (c *Cache[K, T]) put(ctx context.Context, key K, val T) {
+	if c.loadHooks() == nil {
+		c.store(ctx, key, val)
+		return
+	}
+	c.observe(ctx, OpPut, key, func(ctx context.Context) Outcome {
+		c.store(ctx, key, val)
+		return Outcome{}
+	})
+}
+
+// store puts key and val, the evictions it causes are observed with ctx
+func (c *Cache[K, T]) store(ctx context.Context, key K, val T) {
+	var evicted []*node[K, T]
+	defer func() { c.notifyEvicted(ctx, evicted) }()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if el, doesexist := exists(key, c); doesexist {
 		item := el.Value.(*node[K, T])
 		c.items.Remove(el)
 
-		item.usedAt = time.Now()
+		item.usedAt = c.now()
 		item.val = val
 		c.itemIdx[item.key] = c.items.PushFront(item)
 		return
 	}
 
 	// if the capacity is reached the specified limit, remove the last item and add the new item to front
-	if c.items.Len() == c.capacity {
-		bval := c.items.Remove(c.items.Back())
-		delete(c.itemIdx, bval.(*node[K, T]).key)
+	if c.items.Len() >= c.capacity {
+		evicted = c.evict(c.items.Len() - c.capacity + 1)
 	}
 	item := &node[K, T]{
 		key,
 		val,
-		time.Now(),
+		c.now(),
 	}
 	c.itemIdx[item.key] = c.items.PushFront(item)
 }
+
+// Delete removes the given key from cache
+func (c *Cache[K, T]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, doesexist := exists(key, c); doesexist {
+		c.items.Remove(el)
+		delete(c.itemIdx, key)
+	}
+}
+
+// Len returns the number of items in cache, including expired ones not yet cleaned
+func (c *Cache[K, T]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.items.Len()
+}
+
+// Close stops the cleaning of cache items, it always returns nil
+func (c *Cache[K, T]) Close() error {
+	c.PauseCleaning()
+	return nil
+}
+
+// Capacity returns the current maximum number of items the cache holds
+func (c *Cache[K, T]) Capacity() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity
+}
+
+// Resize changes the capacity of the cache, evicting the least recently used items if it shrinks
+func (c *Cache[K, T]) Resize(cacheSize int) error {
+	if cacheSize <= 0 {
+		return fmt.Errorf("invalid cache size, must be greater than 0")
+	}
+	var evicted []*node[K, T]
+	defer func() { c.notifyEvicted(context.Background(), evicted) }()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capacity = cacheSize
+	if n := c.items.Len() - c.capacity; n > 0 {
+		evicted = c.evict(n)
+	}
+	return nil
+}
+
+// OnEvict sets fn to be called for every item evicted to make room, it is called without holding
+// the cache lock so it may use the cache
+func (c *Cache[K, T]) OnEvict(fn EvictFunc[K, T]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvict = fn
+}
+
+// SetClock sets the clock the ttls are measured with, time.Now by default
+func (c *Cache[K, T]) SetClock(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// evict removes n items from the back of items and returns them, c.mu must be held
+func (c *Cache[K, T]) evict(n int) []*node[K, T] {
+	var evicted []*node[K, T]
+	for ; n > 0 && c.items.Len() > 0; n-- {
+		bval := c.items.Remove(c.items.Back())
+		item := bval.(*node[K, T])
+		delete(c.itemIdx, item.key)
+		evicted = append(evicted, item)
+	}
+	return evicted
+}
+
+func (c *Cache[K, T]) notifyEvicted(ctx context.Context, evicted []*node[K, T]) {
+	if len(evicted) == 0 {
+		return
+	}
+	c.mu.Lock()
+	onEvict, ttl := c.onEvict, c.ttl
+	c.mu.Unlock()
+	for _, item := range evicted {
+		c.observe(ctx, OpEvict, item.key, func(context.Context) Outcome {
+			if onEvict != nil {
+				onEvict(item.key, item.val, item.usedAt.Add(ttl))
+			}
+			return Outcome{}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package lru
 
 import (
+	"cache"
+	"cache/cachetest"
+	"context"
 	"math/rand"
 	"sync"
 	"testing"
@@ -94,6 +97,32 @@ func TestCache(t *testing.T) {
 		}
 	})
 
+	t.Run("test cache resize", func(t *testing.T) {
+		lruCache, err := NewCache[string, string](5, 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(testcases); i++ {
+			testcase := testcases[i]
+			lruCache.Put(testcase.key, testcase.val)
+		}
+		if err := lruCache.Resize(2); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"foo", "john", "john1"} {
+			if _, ok := lruCache.Get(key); ok {
+				t.Errorf("key \"%s\" should not be present", key)
+			}
+		}
+		lruCache.Put("john4", "doe4")
+		if _, ok := lruCache.Get("john2"); ok {
+			t.Errorf("key \"%s\" should not be present", "john2")
+		}
+		if err := lruCache.Resize(0); err == nil {
+			t.Errorf("resize to 0 should fail")
+		}
+	})
+
 	t.Run("test concurrent cache usage", func(t *testing.T) {
 		lru, err := NewCache[int, int](1000, 10*time.Second)
 		if err != nil {
@@ -216,3 +245,40 @@ func BenchmarkCache(b *testing.B) {
 		}
 	})
 }
+
+func TestConformance(t *testing.T) {
+	clock := &cachetest.Clock{}
+	cachetest.RunWith(t, func(capacity int, ttl time.Duration) (cache.Cache[string, string], error) {
+		c, err := NewCache[string, string](capacity, ttl)
+		if err != nil {
+			return nil, err
+		}
+		c.now = clock.Now
+		return c, nil
+	}, cachetest.Options{Clock: clock})
+}
+
+func TestRemoveExpired(t *testing.T) {
+	c, err := NewCache[string, string](10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < 5; i++ {
+		c.Put(randSeq(8), "val")
+	}
+	c.Put("fresh", "val")
+	// every item but the most recent one expired
+	for e := c.items.Front().Next(); e != nil; e = e.Next() {
+		e.Value.(*node[string, string]).usedAt = time.Now().Add(-time.Hour)
+	}
+	if n := c.removeExpired(context.Background()); n != 5 {
+		t.Errorf("wanted 5 items removed but got %d", n)
+	}
+	if n := c.Len(); n != 1 {
+		t.Errorf("wanted len 1 but got %d", n)
+	}
+	if _, ok := c.Get("fresh"); !ok {
+		t.Errorf("key \"%s\" should be present", "fresh")
+	}
+}
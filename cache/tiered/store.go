@@ -0,0 +1,386 @@
+package tiered
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt = ".seg"
+
+	// record header: crc32 | flags | expires at (unix nano) | key length | value length
+	headerSize = 4 + 1 + 8 + 4 + 4
+
+	flagTombstone byte = 1
+
+	// DefaultMaxSegmentSize is the size at which the active segment is rotated
+	DefaultMaxSegmentSize int64 = 64 << 20
+	// minCompactBytes avoids compacting stores that are too small to matter
+	minCompactBytes int64 = 1 << 20
+)
+
+var errCorrupt = errors.New("corrupt record")
+
+type location struct {
+	seg     int
+	off     int64
+	size    int64
+	expires int64
+}
+
+// Store is an append-only segment log of key value records with an in-memory hash index,
+// records that are overwritten, deleted or expired are dropped when the log is compacted
+type Store struct {
+	mu             sync.Mutex
+	dir            string
+	maxSegmentSize int64
+
+	now      func() time.Time
+	index    map[string]location
+	segments map[int]*os.File
+	active   int
+	activeSz int64
+	total    int64
+	dead     int64
+}
+
+// OpenStore opens or creates a store in dir, recovering the index from existing segments.
+// Segments with a torn or corrupt tail, as left by a crash, are truncated to their last good record.
+func OpenStore(dir string, maxSegmentSize int64) (*Store, error) {
+	if maxSegmentSize <= 0 {
+		maxSegmentSize = DefaultMaxSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:            dir,
+		maxSegmentSize: maxSegmentSize,
+		now:            time.Now,
+		index:          make(map[string]location),
+		segments:       make(map[int]*os.File),
+	}
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := s.recover(id); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if len(ids) == 0 {
+		if err := s.rotate(1); err != nil {
+			return nil, err
+		}
+	} else {
+		s.active = ids[len(ids)-1]
+		s.activeSz, err = s.segments[s.active].Seek(0, io.SeekEnd)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) segmentIDs() ([]int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (s *Store) segmentPath(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+// recover replays segment id into the index, truncating it at the first bad record
+func (s *Store) recover(id int) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	s.segments[id] = f
+
+	r := bufio.NewReader(f)
+	now := s.now().UnixNano()
+	var off int64
+	for {
+		flags, expires, key, _, size, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// torn write or corruption, everything after off is unusable
+			if err := f.Truncate(off); err != nil {
+				return fmt.Errorf("truncate segment %d failed with %v", id, err)
+			}
+			break
+		}
+		s.total += size
+		k := string(key)
+		if old, ok := s.index[k]; ok {
+			s.dead += old.size
+			delete(s.index, k)
+		}
+		switch {
+		case flags&flagTombstone != 0:
+			s.dead += size
+		case expires <= now:
+			s.dead += size
+		default:
+			s.index[k] = location{seg: id, off: off, size: size, expires: expires}
+		}
+		off += size
+	}
+	return nil
+}
+
+func readRecord(r io.Reader) (flags byte, expires int64, key, val []byte, size int64, err error) {
+	var hdr [headerSize]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errCorrupt
+		}
+		return
+	}
+	sum := binary.BigEndian.Uint32(hdr[0:4])
+	flags = hdr[4]
+	expires = int64(binary.BigEndian.Uint64(hdr[5:13]))
+	klen := binary.BigEndian.Uint32(hdr[13:17])
+	vlen := binary.BigEndian.Uint32(hdr[17:21])
+	body := make([]byte, int(klen)+int(vlen))
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = errCorrupt
+		return
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(body)
+	if crc.Sum32() != sum {
+		err = errCorrupt
+		return
+	}
+	key, val = body[:klen], body[klen:]
+	size = int64(headerSize + len(body))
+	return
+}
+
+func encodeRecord(flags byte, expires int64, key, val []byte) []byte {
+	b := make([]byte, headerSize+len(key)+len(val))
+	b[4] = flags
+	binary.BigEndian.PutUint64(b[5:13], uint64(expires))
+	binary.BigEndian.PutUint32(b[13:17], uint32(len(key)))
+	binary.BigEndian.PutUint32(b[17:21], uint32(len(val)))
+	copy(b[headerSize:], key)
+	copy(b[headerSize+len(key):], val)
+	binary.BigEndian.PutUint32(b[0:4], crc32.ChecksumIEEE(b[4:]))
+	return b
+}
+
+// rotate makes a new empty segment id the active one, s.mu must be held
+func (s *Store) rotate(id int) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if cur, ok := s.segments[s.active]; ok {
+		if err := cur.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	s.segments[id] = f
+	s.active = id
+	s.activeSz = 0
+	return nil
+}
+
+// appendRecord writes a record to the active segment, s.mu must be held
+func (s *Store) appendRecord(flags byte, expires int64, key, val []byte) (location, error) {
+	if s.activeSz >= s.maxSegmentSize {
+		if err := s.rotate(s.active + 1); err != nil {
+			return location{}, err
+		}
+	}
+	b := encodeRecord(flags, expires, key, val)
+	if _, err := s.segments[s.active].WriteAt(b, s.activeSz); err != nil {
+		return location{}, err
+	}
+	loc := location{seg: s.active, off: s.activeSz, size: int64(len(b)), expires: expires}
+	s.activeSz += loc.size
+	s.total += loc.size
+	return loc, nil
+}
+
+// Put stores val under key until expiresAt
+func (s *Store) Put(key, val []byte, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, err := s.appendRecord(0, expiresAt.UnixNano(), key, val)
+	if err != nil {
+		return err
+	}
+	k := string(key)
+	if old, ok := s.index[k]; ok {
+		s.dead += old.size
+	}
+	s.index[k] = loc
+	return s.maybeCompact()
+}
+
+// Get returns the value stored under key and when it expires
+func (s *Store) Get(key []byte) ([]byte, time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := string(key)
+	loc, ok := s.index[k]
+	if !ok {
+		return nil, time.Time{}, false, nil
+	}
+	if loc.expires <= s.now().UnixNano() {
+		delete(s.index, k)
+		s.dead += loc.size
+		return nil, time.Time{}, false, nil
+	}
+	f := s.segments[loc.seg]
+	_, _, _, val, _, err := readRecord(io.NewSectionReader(f, loc.off, loc.size))
+	if err != nil {
+		return nil, time.Time{}, false, fmt.Errorf("read segment %d at %d failed with %v", loc.seg, loc.off, err)
+	}
+	return val, time.Unix(0, loc.expires), true, nil
+}
+
+// Delete removes key, it writes a tombstone only if the key is present
+func (s *Store) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := string(key)
+	old, ok := s.index[k]
+	if !ok {
+		return nil
+	}
+	loc, err := s.appendRecord(flagTombstone, 0, key, nil)
+	if err != nil {
+		return err
+	}
+	delete(s.index, k)
+	s.dead += old.size + loc.size
+	return s.maybeCompact()
+}
+
+// Len returns the number of keys in the store, including expired ones not yet dropped
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.index)
+}
+
+func (s *Store) maybeCompact() error {
+	if s.total < minCompactBytes || s.dead*2 < s.total {
+		return nil
+	}
+	return s.compact()
+}
+
+// Compact rewrites the live records into new segments and removes the old ones
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+func (s *Store) compact() error {
+	old := make([]int, 0, len(s.segments))
+	for id := range s.segments {
+		old = append(old, id)
+	}
+	sort.Ints(old)
+
+	if err := s.rotate(s.active + 1); err != nil {
+		return err
+	}
+	now := s.now().UnixNano()
+	index := make(map[string]location, len(s.index))
+	s.total, s.dead = 0, 0
+	for k, loc := range s.index {
+		if loc.expires <= now {
+			continue
+		}
+		_, _, key, val, _, err := readRecord(io.NewSectionReader(s.segments[loc.seg], loc.off, loc.size))
+		if err != nil {
+			return fmt.Errorf("read segment %d at %d failed with %v", loc.seg, loc.off, err)
+		}
+		nloc, err := s.appendRecord(0, loc.expires, key, val)
+		if err != nil {
+			return err
+		}
+		index[k] = nloc
+	}
+	if err := s.segments[s.active].Sync(); err != nil {
+		return err
+	}
+	s.index = index
+	// remove oldest first, so a crash part way never leaves a tombstone's segment removed
+	// while the segment holding the record it deletes survives
+	for _, id := range old {
+		s.segments[id].Close()
+		delete(s.segments, id)
+		if err := os.Remove(s.segmentPath(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetClock sets the clock expiries are checked against, time.Now by default, records
+// recovered when the store was opened were checked against time.Now
+func (s *Store) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// Close syncs and closes all segments
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if f, ok := s.segments[s.active]; ok {
+		err = f.Sync()
+	}
+	for id, f := range s.segments {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.segments, id)
+	}
+	return err
+}
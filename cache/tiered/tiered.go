@@ -0,0 +1,215 @@
+// Package tiered provides a two tier cache, an in-memory lru.Cache backed by an on-disk Store.
+package tiered
+
+import (
+	"bytes"
+	"cache"
+	"cache/lru"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Codec converts values to and from bytes for the disk tier
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(b []byte) (T, error)
+}
+
+// GobCodec is a Codec using encoding/gob
+type GobCodec[T any] struct{}
+
+// Marshal encodes v with gob
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes b with gob
+func (GobCodec[T]) Unmarshal(b []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+var _ cache.Cache[string, string] = (*Cache[string, string])(nil)
+
+// Cache keeps recently used items in memory and demotes items evicted from memory to disk,
+// items found on disk are promoted back to memory. The ttl of an item holds across both tiers.
+type Cache[K constraints.Ordered, V any] struct {
+	mu   sync.Mutex
+	l1   *lru.Cache[K, V]
+	l2   *Store
+	now  func() time.Time
+	keys Codec[K]
+	vals Codec[V]
+
+	errMu sync.Mutex
+	err   error
+}
+
+// NewCache returns a tiered cache holding cacheSize items in memory and the rest in dir,
+// using gob to encode keys and values for the disk tier
+func NewCache[K constraints.Ordered, V any](cacheSize int, cacheItemTtl time.Duration, dir string) (*Cache[K, V], error) {
+	return NewCacheWithCodecs[K, V](cacheSize, cacheItemTtl, dir, GobCodec[K]{}, GobCodec[V]{})
+}
+
+// NewCacheWithCodecs is NewCache with custom codecs for keys and values
+func NewCacheWithCodecs[K constraints.Ordered, V any](cacheSize int, cacheItemTtl time.Duration, dir string, keys Codec[K], vals Codec[V]) (*Cache[K, V], error) {
+	l1, err := lru.NewCache[K, V](cacheSize, cacheItemTtl)
+	if err != nil {
+		return nil, err
+	}
+	l2, err := OpenStore(dir, DefaultMaxSegmentSize)
+	if err != nil {
+		l1.Close()
+		return nil, err
+	}
+	c := &Cache[K, V]{
+		l1:   l1,
+		l2:   l2,
+		now:  time.Now,
+		keys: keys,
+		vals: vals,
+	}
+	l1.OnEvict(c.demote)
+	return c, nil
+}
+
+// demote writes an item evicted from memory to disk, unless its ttl ran out
+func (c *Cache[K, V]) demote(key K, val V, expiresAt time.Time) {
+	if !c.now().Before(expiresAt) {
+		return
+	}
+	kb, err := c.keys.Marshal(key)
+	if err != nil {
+		c.setErr(fmt.Errorf("marshal key %v failed with %v", key, err))
+		return
+	}
+	vb, err := c.vals.Marshal(val)
+	if err != nil {
+		c.setErr(fmt.Errorf("marshal value of %v failed with %v", key, err))
+		return
+	}
+	if err := c.l2.Put(kb, vb, expiresAt); err != nil {
+		c.setErr(fmt.Errorf("demote %v failed with %v", key, err))
+	}
+}
+
+// SetClock sets the clock the ttls of both tiers are measured with, time.Now by default, it
+// must be set before the cache is used
+func (c *Cache[K, V]) SetClock(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	c.l1.SetClock(now)
+	c.l2.SetClock(now)
+}
+
+func (c *Cache[K, V]) setErr(err error) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	c.err = err
+}
+
+// Err returns and clears the last error of the disk tier, demotions happen during Put so
+// their errors can not be returned there
+func (c *Cache[K, V]) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	err := c.err
+	c.err = nil
+	return err
+}
+
+// Get returns the value of key from memory or, promoting it to memory, from disk
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	if v, ok := c.l1.Get(key); ok {
+		return v, true
+	}
+	var zero V
+	kb, err := c.keys.Marshal(key)
+	if err != nil {
+		c.setErr(fmt.Errorf("marshal key %v failed with %v", key, err))
+		return zero, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// another goroutine may have promoted or put key while we waited
+	if v, ok := c.l1.Get(key); ok {
+		return v, true
+	}
+	vb, _, ok, err := c.l2.Get(kb)
+	if err != nil {
+		c.setErr(err)
+		return zero, false
+	}
+	if !ok {
+		return zero, false
+	}
+	v, err := c.vals.Unmarshal(vb)
+	if err != nil {
+		c.setErr(fmt.Errorf("unmarshal value of %v failed with %v", key, err))
+		return zero, false
+	}
+	if err := c.l2.Delete(kb); err != nil {
+		c.setErr(err)
+	}
+	c.l1.Put(key, v)
+	return v, true
+}
+
+// Put puts the given k, v in memory, dropping any older copy on disk
+func (c *Cache[K, V]) Put(key K, val V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.l1.Put(key, val)
+	kb, err := c.keys.Marshal(key)
+	if err != nil {
+		c.setErr(fmt.Errorf("marshal key %v failed with %v", key, err))
+		return
+	}
+	if err := c.l2.Delete(kb); err != nil {
+		c.setErr(err)
+	}
+}
+
+// Delete removes key from both tiers
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.l1.Delete(key)
+	kb, err := c.keys.Marshal(key)
+	if err != nil {
+		c.setErr(fmt.Errorf("marshal key %v failed with %v", key, err))
+		return
+	}
+	if err := c.l2.Delete(kb); err != nil {
+		c.setErr(err)
+	}
+}
+
+// Len returns the number of items in both tiers
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.l1.Len() + c.l2.Len()
+}
+
+// Compact compacts the disk tier
+func (c *Cache[K, V]) Compact() error {
+	return c.l2.Compact()
+}
+
+// Close stops the memory tier's cleaning and closes the disk tier, items only in memory are lost
+func (c *Cache[K, V]) Close() error {
+	c.l1.Close()
+	return c.l2.Close()
+}
@@ -0,0 +1,165 @@
+package tiered
+
+import (
+	"cache"
+	"cache/cachetest"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("test demote and promote", func(t *testing.T) {
+		c, err := NewCache[string, string](2, time.Minute, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Put("foo", "bar")
+		c.Put("john", "doe")
+		c.Put("john1", "doe1") // demotes foo
+		if n := c.l2.Len(); n != 1 {
+			t.Errorf("wanted 1 item on disk but got %d", n)
+		}
+		val, ok := c.Get("foo") // promotes foo, demotes john
+		if !ok || val != "bar" {
+			t.Errorf("wanted bar but got %q, %v", val, ok)
+		}
+		if _, ok := c.l1.Get("foo"); !ok {
+			t.Errorf("key \"%s\" should be in memory after promotion", "foo")
+		}
+		if val, ok := c.Get("john"); !ok || val != "doe" {
+			t.Errorf("wanted doe but got %q, %v", val, ok)
+		}
+		if err := c.Err(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("test put replaces value on disk", func(t *testing.T) {
+		c, err := NewCache[string, string](1, time.Minute, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Put("foo", "bar")
+		c.Put("john", "doe") // demotes foo
+		c.Put("foo", "baz")  // demotes john, drops foo from disk
+		c.Put("john1", "doe1")
+		if val, ok := c.Get("foo"); !ok || val != "baz" {
+			t.Errorf("wanted baz but got %q, %v", val, ok)
+		}
+	})
+
+	t.Run("test ttl across tiers", func(t *testing.T) {
+		c, err := NewCache[string, string](1, 200*time.Millisecond, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Put("foo", "bar")
+		c.Put("john", "doe") // demotes foo
+		time.Sleep(300 * time.Millisecond)
+		if _, ok := c.Get("foo"); ok {
+			t.Errorf("key \"%s\" should have expired on disk", "foo")
+		}
+	})
+}
+
+func TestStore(t *testing.T) {
+	t.Run("test recovery after torn write", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenStore(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := time.Now().Add(time.Hour)
+		for i := 0; i < 10; i++ {
+			if err := s.Put([]byte(fmt.Sprint("key", i)), []byte(fmt.Sprint("val", i)), exp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Delete([]byte("key3")); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		// simulate a crash in the middle of appending a record
+		path := filepath.Join(dir, "00000001.seg")
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(encodeRecord(0, exp.UnixNano(), []byte("key10"), []byte("val10"))[:headerSize+2])
+		f.Close()
+
+		s, err = OpenStore(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		if n := s.Len(); n != 9 {
+			t.Errorf("wanted 9 keys after recovery but got %d", n)
+		}
+		if _, _, ok, _ := s.Get([]byte("key3")); ok {
+			t.Errorf("key \"%s\" should stay deleted", "key3")
+		}
+		val, _, ok, err := s.Get([]byte("key9"))
+		if err != nil || !ok || string(val) != "val9" {
+			t.Errorf("wanted val9 but got %q, %v, %v", val, ok, err)
+		}
+		if err := s.Put([]byte("key10"), []byte("val10"), exp); err != nil {
+			t.Fatal(err)
+		}
+		if val, _, ok, _ := s.Get([]byte("key10")); !ok || string(val) != "val10" {
+			t.Errorf("wanted val10 but got %q, %v", val, ok)
+		}
+	})
+
+	t.Run("test compaction", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenStore(dir, 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := time.Now().Add(time.Hour)
+		for i := 0; i < 50; i++ {
+			if err := s.Put([]byte(fmt.Sprint("key", i%5)), []byte(fmt.Sprint("val", i)), exp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		s, err = OpenStore(dir, 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		if s.dead != 0 {
+			t.Errorf("wanted no dead bytes after compaction but got %d", s.dead)
+		}
+		for i := 45; i < 50; i++ {
+			key := fmt.Sprint("key", i%5)
+			val, _, ok, err := s.Get([]byte(key))
+			if err != nil || !ok || string(val) != fmt.Sprint("val", i) {
+				t.Errorf("wanted val%d for %s but got %q, %v, %v", i, key, val, ok, err)
+			}
+		}
+	})
+}
+
+func TestConformance(t *testing.T) {
+	clock := &cachetest.Clock{}
+	cachetest.RunWith(t, func(capacity int, ttl time.Duration) (cache.Cache[string, string], error) {
+		c, err := NewCache[string, string](capacity, ttl, t.TempDir())
+		if err != nil {
+			return nil, err
+		}
+		c.SetClock(clock.Now)
+		return c, nil
+	}, cachetest.Options{Unbounded: true, Clock: clock})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gget/daemon"
+	"gget/gget"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serve runs the daemon on daemonAddr until interrupted and returns the exit code
+func serve(ctx context.Context, opts []gget.Option) int {
+	token := rpcToken
+	if token == "" {
+		token = os.Getenv("GGET_RPC_TOKEN")
+	}
+	ln, err := net.Listen("tcp", daemonAddr)
+	if err != nil {
+		fmt.Printf("error while listening: %v\n", err)
+		return 2
+	}
+	if addr, ok := ln.Addr().(*net.TCPAddr); token == "" && (!ok || !addr.IP.IsLoopback()) {
+		fmt.Printf("error: -rpc-token is required to serve on %s, anyone reaching it would control the downloads\n", ln.Addr())
+		ln.Close()
+		return 2
+	}
+	if token == "" {
+		fmt.Printf("warning: no -rpc-token, any local user reaching %s controls the downloads\n", ln.Addr())
+	}
+	s, err := daemon.New(ctx, outDir, token, opts...)
+	if err != nil {
+		fmt.Printf("error while starting: %v\n", err)
+		ln.Close()
+		return 2
+	}
+	if localFiles {
+		s.AllowAnyScheme()
+	}
+	fmt.Printf("serving on %s\n", ln.Addr())
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	code := 0
+	select {
+	case <-ctx.Done():
+	case err := <-served:
+		fmt.Printf("error while serving: %v\n", err)
+		code = 1
+	}
+	// closing the daemon ends the event streams, which Shutdown would wait for
+	rep, _ := s.Close()
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdown)
+	if rep != nil && report != "" {
+		if err := writeReport(rep, report); err != nil {
+			fmt.Printf("error while writing report: %v\n", err)
+		}
+	}
+	return code
+}
@@ -5,19 +5,141 @@ import (
 	"flag"
 	"fmt"
 	"gget/gget"
+	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"strings"
 	"syscall"
+	"time"
 )
 
 var (
-	outDir   string
-	routines int
+	outDir    string
+	routines  int
+	retry     = gget.DefaultRetryPolicy
+	keepGoing bool
+	report    string
+	progress  string
+
+	input      string
+	format     string
+	localFiles bool
+
+	checksumFiles stringsFlag
+	quarantine    string
+
+	limitRate        string
+	limitRatePerHost string
+
+	maxPerHost int
+	hostDelay  time.Duration
+
+	onConflict string
+	mirror     bool
+
+	mirrorOrder    string
+	mirrorSegments bool
+
+	recursive bool
+	crawl     gget.Crawl
+	scope     string
+	includes  stringsFlag
+	excludes  stringsFlag
+
+	headers   stringsFlag
+	userAgent string
+	user      string
+	bearer    string
+	useNetrc  bool
+	netrcFile string
+	cookies   string
+	postData  string
+	postFile  string
+	postType  string
+
+	transport = gget.DefaultTransportConfig
+
+	extract       bool
+	deleteArchive bool
+
+	storeDir  string
+	storeLink string
+
+	daemonAddr string
+	rpcToken   string
 )
 
+// stringsFlag is a flag that can be given several times
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func init() {
 	flag.StringVar(&outDir, "outdir", "./", "output directory")
-	flag.IntVar(&routines, "routines", 2, "specifies number of routines used to download at a time")
+	flag.IntVar(&routines, "routines", 2, "specifies number of routines used to download at a time, shared between files and segments of large files")
+	flag.IntVar(&retry.MaxAttempts, "attempts", retry.MaxAttempts, "specifies number of attempts per url, 1 disables retries")
+	flag.DurationVar(&retry.BaseDelay, "retry-base", retry.BaseDelay, "wait after the first failed attempt, doubled after each further one")
+	flag.DurationVar(&retry.MaxDelay, "retry-max", retry.MaxDelay, "maximum wait between attempts, including waits asked for by Retry-After")
+	flag.Float64Var(&retry.Jitter, "retry-jitter", retry.Jitter, "fraction of each wait between attempts that is randomized")
+	flag.BoolVar(&keepGoing, "keep-going", false, "keep downloading the remaining urls after one fails")
+	flag.StringVar(&report, "report", "", "write a JSON report of every url to this file, - for stdout with the summary table on stderr")
+	flag.Var(&checksumFiles, "checksums", "verify files against a checksum list such as SHA256SUMS, can be given several times")
+	flag.StringVar(&quarantine, "quarantine", "", "move files failing verification into this directory instead of deleting them")
+	flag.StringVar(&limitRate, "limit-rate", "", "limit all downloads together to this many bytes per second, k, m and g suffixes are allowed")
+	flag.StringVar(&limitRatePerHost, "limit-rate-per-host", "", "limit the downloads from each host to this many bytes per second")
+	flag.IntVar(&maxPerHost, "max-per-host", 0, "maximum concurrent connections to each host, 0 for no limit besides -routines")
+	flag.DurationVar(&hostDelay, "host-delay", 0, "minimum wait between requests to the same host")
+	flag.StringVar(&input, "input", "", "read the urls from this file instead of stdin")
+	flag.BoolVar(&localFiles, "local-files", false, "allow file urls, copying local files, in the input or added to the -daemon; mirrors and crawled links are never file urls")
+	flag.StringVar(&format, "input-format", "auto", "input format: lines with indented aria2-style options, jsonl, metalink, or auto to detect it")
+	flag.StringVar(&mirrorOrder, "mirror-order", "in-order", "order the url and the mirrors of a file are tried in: in-order, or fastest as measured")
+	flag.BoolVar(&mirrorSegments, "mirror-segments", false, "fetch the segments of large files from several mirrors at the same time")
+	flag.Var(&headers, "header", "send this header, as Name: value, with every request, can be given several times")
+	flag.StringVar(&userAgent, "user-agent", "", "User-Agent to send")
+	flag.StringVar(&user, "user", "", "basic auth as user:password, the password is read from $GGET_PASSWORD when left out")
+	flag.StringVar(&bearer, "bearer", "", "bearer token to send, read from $GGET_TOKEN when not given")
+	flag.BoolVar(&useNetrc, "netrc", false, "send the logins of $NETRC or ~/.netrc by host")
+	flag.StringVar(&netrcFile, "netrc-file", "", "send the logins of this netrc file by host")
+	flag.StringVar(&cookies, "cookies", "", "keep cookies in this Netscape cookies.txt file, read before and written after downloading")
+	flag.StringVar(&postData, "post-data", "", "download with a POST of this body")
+	flag.StringVar(&postFile, "post-file", "", "download with a POST of the content of this file")
+	flag.StringVar(&postType, "post-type", "application/x-www-form-urlencoded", "Content-Type of the POST body")
+	flag.DurationVar(&transport.ConnectTimeout, "connect-timeout", transport.ConnectTimeout, "maximum time to establish a connection, 0 for no limit")
+	flag.DurationVar(&transport.TLSHandshakeTimeout, "tls-timeout", transport.TLSHandshakeTimeout, "maximum time for the TLS handshake, 0 for no limit")
+	flag.DurationVar(&transport.HeaderTimeout, "header-timeout", transport.HeaderTimeout, "maximum wait for the response headers, 0 for no limit")
+	flag.DurationVar(&transport.IdleTimeout, "idle-timeout", transport.IdleTimeout, "fail and retry a download receiving no data for this long, 0 for no limit")
+	flag.DurationVar(&transport.Timeout, "timeout", transport.Timeout, "maximum time of each request including its body, 0 for no limit")
+	flag.StringVar(&transport.Proxy, "proxy", "", "http, https or socks5 proxy url, direct for none, $HTTP_PROXY and such are used when not given")
+	flag.StringVar(&transport.CAFile, "ca-file", "", "trust the root certificates of this PEM file besides the system ones")
+	flag.StringVar(&transport.CertFile, "cert", "", "PEM client certificate for mutual TLS")
+	flag.StringVar(&transport.KeyFile, "key", "", "PEM key of the -cert client certificate")
+	flag.BoolVar(&transport.InsecureSkipVerify, "insecure", false, "accept any server certificate")
+	flag.StringVar(&transport.HTTPVersion, "http", "", "force http version 1.1 or 2, negotiated when not given")
+	flag.BoolVar(&extract, "extract", false, "extract downloaded tar, zip, gz and bz2 archives into a directory named after each, xz is not supported")
+	flag.BoolVar(&deleteArchive, "delete-archive", false, "delete archives once -extract extracted them")
+	flag.StringVar(&storeDir, "store", "", "keep downloads by their sha256 in this directory, linked to where they are saved, skipping urls whose content is stored")
+	flag.StringVar(&storeLink, "store-link", "hard", "link stored files as hard links, or symlink")
+	flag.StringVar(&onConflict, "on-conflict", "overwrite", "when a file exists: overwrite, skip, rename with a -N suffix, or error")
+	flag.BoolVar(&mirror, "mirror", false, "download a url again only when it changed since the last run, per its ETag and Last-Modified")
+	flag.BoolVar(&recursive, "recursive", false, "follow the links of the html pages downloaded, saving them in a tree of host and path directories")
+	flag.IntVar(&crawl.Depth, "depth", 5, "how many links away from the input urls -recursive goes, 0 for no limit")
+	flag.StringVar(&scope, "scope", "host", "links -recursive follows: host, domain with subdomains, or prefix for the directory of the input url")
+	flag.Var(&includes, "include", "only follow links matching this regular expression, can be given several times")
+	flag.Var(&excludes, "exclude", "do not follow links matching this regular expression, can be given several times")
+	flag.BoolVar(&crawl.IgnoreRobots, "ignore-robots", false, "follow links robots.txt disallows")
+	flag.BoolVar(&crawl.CSS, "css", false, "search stylesheets for links too")
+	flag.BoolVar(&crawl.ConvertLinks, "convert-links", false, "rewrite the links of saved pages to the local files for offline browsing")
+	flag.StringVar(&daemonAddr, "daemon", "", "run as a service on this address, such as localhost:6800, controlled by JSON-RPC at /jsonrpc with events at /events, instead of reading urls")
+	flag.StringVar(&rpcToken, "rpc-token", "", "bearer token the -daemon API requires, read from $GGET_RPC_TOKEN when not given, needed unless it listens on a loopback address")
+	flag.StringVar(&progress, "progress", "auto", "show progress on stderr as bars, json lines, none, or auto for bars on a terminal and json otherwise")
 
 	flag.Parse()
 }
@@ -31,9 +153,238 @@ func main() {
 		cancel()
 	}()
 
-	err := gget.Get(appCtx, os.Stdin, routines, outDir)
+	opts := []gget.Option{gget.WithRoutines(routines), gget.WithRetry(retry), gget.WithTransport(transport)}
+	if keepGoing {
+		opts = append(opts, gget.WithContinueOnError())
+	}
+	for _, path := range checksumFiles {
+		sums, err := readSums(path)
+		if err != nil {
+			fmt.Printf("error while reading checksums: %v\n", err)
+			os.Exit(2)
+		}
+		opts = append(opts, gget.WithChecksums(sums))
+	}
+	if quarantine != "" {
+		opts = append(opts, gget.WithQuarantine(quarantine))
+	}
+	rate, err := gget.ParseRate(limitRate)
+	if err != nil {
+		fmt.Printf("error in -limit-rate: %v\n", err)
+		os.Exit(2)
+	}
+	hostRate, err := gget.ParseRate(limitRatePerHost)
+	if err != nil {
+		fmt.Printf("error in -limit-rate-per-host: %v\n", err)
+		os.Exit(2)
+	}
+	opts = append(opts, gget.WithRateLimit(rate), gget.WithHostRateLimit(hostRate))
+	opts = append(opts, gget.WithHostConnections(maxPerHost), gget.WithHostDelay(hostDelay))
+	conflict, err := gget.ParseConflict(onConflict)
+	if err != nil {
+		fmt.Printf("error in -on-conflict: %v\n", err)
+		os.Exit(2)
+	}
+	opts = append(opts, gget.WithConflict(conflict))
+	if mirror {
+		opts = append(opts, gget.WithMirror())
+	}
+	if extract {
+		opts = append(opts, gget.WithExtract(deleteArchive))
+	}
+	if storeDir != "" {
+		link, err := gget.ParseLinkMode(storeLink)
+		if err != nil {
+			fmt.Printf("error in -store-link: %v\n", err)
+			os.Exit(2)
+		}
+		opts = append(opts, gget.WithStore(storeDir, link))
+	}
+	if recursive {
+		if err := crawlOptions(); err != nil {
+			fmt.Printf("error in recursive options: %v\n", err)
+			os.Exit(2)
+		}
+		opts = append(opts, gget.WithCrawl(crawl))
+	}
+	show, err := progressFunc(progress, os.Stderr)
+	if err != nil {
+		fmt.Printf("error in -progress: %v\n", err)
+		os.Exit(2)
+	}
+	if show != nil {
+		opts = append(opts, gget.WithProgress(show, 0))
+	}
+	order, err := gget.ParseMirrorOrder(mirrorOrder)
+	if err != nil {
+		fmt.Printf("error in -mirror-order: %v\n", err)
+		os.Exit(2)
+	}
+	opts = append(opts, gget.WithMirrorOrder(order))
+	if mirrorSegments {
+		opts = append(opts, gget.WithMirrorSegments())
+	}
+	reqOpts, err := requestOptions()
+	if err != nil {
+		fmt.Printf("error in request options: %v\n", err)
+		os.Exit(2)
+	}
+	opts = append(opts, reqOpts...)
+	inputFormat, err := gget.ParseInputFormat(format)
+	if err != nil {
+		fmt.Printf("error in -input-format: %v\n", err)
+		os.Exit(2)
+	}
+	opts = append(opts, gget.WithInputFormat(inputFormat))
+	if localFiles {
+		opts = append(opts, gget.WithLocalFiles())
+	}
+	if daemonAddr != "" {
+		code := serve(appCtx, opts)
+		signal.Stop(interruptions)
+		os.Exit(code)
+	}
+	in := os.Stdin
+	if input != "" {
+		if in, err = os.Open(input); err != nil {
+			fmt.Printf("error while opening input: %v\n", err)
+			os.Exit(2)
+		}
+		defer in.Close()
+	}
+	d, err := gget.NewDownloader(appCtx, outDir, opts...)
+	if err != nil {
+		fmt.Printf("error while starting: %v\n", err)
+		os.Exit(2)
+	}
+	readErr := d.SubmitFrom(in)
+	rep, err := d.Close()
+	if readErr != nil {
+		err = readErr
+	}
+	if rep != nil {
+		// stdout carries only the JSON report when it is written there
+		table := os.Stdout
+		if report == "-" {
+			table = os.Stderr
+		}
+		rep.WriteTable(table)
+		if report != "" {
+			if err := writeReport(rep, report); err != nil {
+				fmt.Printf("error while writing report: %v\n", err)
+			}
+		}
+	}
 	if err != nil {
 		fmt.Printf("error while downloding: %v\n", err)
 	}
+	signal.Stop(interruptions)
 	close(interruptions)
+	if err != nil || (rep != nil && rep.Failed() > 0) {
+		os.Exit(1)
+	}
+}
+
+func requestOptions() ([]gget.Option, error) {
+	var opts []gget.Option
+	if len(headers) > 0 {
+		h := http.Header{}
+		for _, line := range headers {
+			k, v, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid header %q, want Name: value", line)
+			}
+			h.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+		opts = append(opts, gget.WithHeader(h))
+	}
+	if userAgent != "" {
+		opts = append(opts, gget.WithUserAgent(userAgent))
+	}
+	if user != "" {
+		name, password, ok := strings.Cut(user, ":")
+		if !ok {
+			password = os.Getenv("GGET_PASSWORD")
+		}
+		opts = append(opts, gget.WithBasicAuth(name, password))
+	}
+	if bearer == "" {
+		bearer = os.Getenv("GGET_TOKEN")
+	}
+	if bearer != "" {
+		opts = append(opts, gget.WithBearerToken(bearer))
+	}
+	if useNetrc || netrcFile != "" {
+		n, err := gget.LoadNetrc(netrcFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, gget.WithNetrc(n))
+	}
+	if cookies != "" {
+		opts = append(opts, gget.WithCookieFile(cookies))
+	}
+	switch {
+	case postData != "" && postFile != "":
+		return nil, fmt.Errorf("-post-data and -post-file are exclusive")
+	case postData != "":
+		opts = append(opts, gget.WithPostBody(postType, []byte(postData)))
+	case postFile != "":
+		body, err := os.ReadFile(postFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, gget.WithPostBody(postType, body))
+	}
+	return opts, nil
+}
+
+func crawlOptions() error {
+	var err error
+	if crawl.Scope, err = gget.ParseScope(scope); err != nil {
+		return err
+	}
+	for _, expr := range includes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		crawl.Include = append(crawl.Include, re)
+	}
+	for _, expr := range excludes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		crawl.Exclude = append(crawl.Exclude, re)
+	}
+	return nil
+}
+
+func writeReport(rep *gget.Report, path string) error {
+	if path == "-" {
+		return rep.WriteJSON(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := rep.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readSums(path string) (map[string]*gget.Checksum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sums, err := gget.ParseSums(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sums, nil
 }
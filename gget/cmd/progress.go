@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gget/gget"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressFunc returns how progress is shown for mode, nil when it is not
+func progressFunc(mode string, w *os.File) (gget.ProgressFunc, error) {
+	switch mode {
+	case "auto":
+		if isTerminal(w) {
+			return (&bars{w: w}).draw, nil
+		}
+		return jsonEvents(w), nil
+	case "bars":
+		return (&bars{w: w}).draw, nil
+	case "json":
+		return jsonEvents(w), nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown progress mode %q, expected auto, bars, json or none", mode)
+}
+
+// jsonEvents writes every snapshot as a line of JSON
+func jsonEvents(w io.Writer) gget.ProgressFunc {
+	enc := json.NewEncoder(w)
+	return func(p gget.Progress) {
+		enc.Encode(p)
+	}
+}
+
+// bars redraws a line per active file and a summary line in place
+type bars struct {
+	w     io.Writer
+	lines int
+}
+
+const barWidth = 24
+
+func (b *bars) draw(p gget.Progress) {
+	var sb strings.Builder
+	if b.lines > 0 {
+		// back to the first line drawn last time
+		fmt.Fprintf(&sb, "\x1b[%dA", b.lines)
+	}
+	b.lines = 0
+	if !p.Final {
+		for _, f := range p.Files {
+			name := f.Name
+			if name == "" {
+				name = f.URL
+			}
+			sb.WriteString("\x1b[2K")
+			fmt.Fprintf(&sb, "%-30s %s %s\n", truncate(name, 30), bar(f.Bytes, f.Size), stats(f.Bytes, f.Size, f.Rate, f.ETA))
+			b.lines++
+		}
+	}
+	sb.WriteString("\x1b[2K")
+	fmt.Fprintf(&sb, "%d active, %d done, %d failed  %s\n", p.Active, p.Done, p.Failed, stats(p.Bytes, p.Total, p.Rate, p.ETA))
+	b.lines++
+	// clear what is left of a longer earlier drawing
+	sb.WriteString("\x1b[J")
+	if p.Final {
+		b.lines = 0
+	}
+	io.WriteString(b.w, sb.String())
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n+3:]
+}
+
+func bar(bytes, size int64) string {
+	if size <= 0 {
+		return "[" + strings.Repeat("?", barWidth) + "]"
+	}
+	done := int(float64(barWidth) * float64(bytes) / float64(size))
+	if done > barWidth {
+		done = barWidth
+	}
+	return "[" + strings.Repeat("=", done) + strings.Repeat(" ", barWidth-done) + "]"
+}
+
+func stats(bytes, size int64, rate float64, eta time.Duration) string {
+	s := humanBytes(float64(bytes))
+	if size >= 0 {
+		s += " / " + humanBytes(float64(size))
+	}
+	s += "  " + humanBytes(rate) + "/s"
+	if eta >= 0 {
+		s += "  eta " + eta.Round(time.Second).String()
+	}
+	return s
+}
+
+func humanBytes(n float64) string {
+	const units = "KMGT"
+	if n < 1024 {
+		return fmt.Sprintf("%.0fB", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", n, units[i])
+}
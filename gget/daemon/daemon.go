@@ -0,0 +1,577 @@
+// Package daemon runs a gget Downloader as a long lived service controlled over HTTP. It
+// answers JSON-RPC 2.0 requests POSTed to /jsonrpc and pushes server-sent events from /events.
+//
+// The methods take named params:
+//
+//	add       url, mirrors, output, header, checksum, priority; returns the id of the download,
+//	          urls must be http, https, ftp or ftps unless the server allows any scheme
+//	pause     id; returns the download
+//	resume    id; returns the download
+//	remove    id; cancels the download and returns it
+//	priority  id, priority; returns the download
+//	status    id; returns the download
+//	list      returns the active, waiting and stopped downloads
+//	purge     forgets the stopped downloads, returns how many
+//
+// A job event carries a download whenever its status changes, a progress event carries the
+// active downloads every 500ms while there are some.
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gget/gget"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSON-RPC error codes
+const (
+	codeParse          = -32700
+	codeInvalidRequest = -32600
+	codeMethod         = -32601
+	codeParams         = -32602
+	// codeJob is the error of a method failing on the download it names
+	codeJob = 1
+)
+
+// maxRequest caps the size of a request body
+const maxRequest = 1 << 20
+
+// Server serves a Downloader over HTTP
+type Server struct {
+	d     *gget.Downloader
+	token string
+	// anyScheme lets add take urls of schemes other than the network ones
+	anyScheme bool
+
+	mu     sync.Mutex
+	jobs   map[string]*entry
+	ids    []string
+	next   int
+	subs   map[chan event]struct{}
+	closed bool
+}
+
+// entry is a download added to the server
+type entry struct {
+	id string
+	h  *gget.JobHandle
+	// notified is the status the clients were last told of
+	notified gget.JobStatus
+}
+
+// event is a server-sent event
+type event struct {
+	name string
+	data []byte
+}
+
+// New starts a downloader saving into outdir with opts, which keeps going when downloads fail
+// and reports progress to the clients every 500ms. Requests must carry token as a bearer token,
+// or as the token query parameter for browsers' EventSource, unless it is empty.
+func New(ctx context.Context, outdir, token string, opts ...gget.Option) (*Server, error) {
+	s := &Server{
+		token: token,
+		jobs:  make(map[string]*entry),
+		subs:  make(map[chan event]struct{}),
+	}
+	opts = append(opts, gget.WithContinueOnError(), gget.WithProgress(s.progress, 0))
+	d, err := gget.NewDownloader(ctx, outdir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.d = d
+	return s, nil
+}
+
+// networkSchemes are the schemes add takes unless the server allows any scheme
+var networkSchemes = map[string]bool{"http": true, "https": true, "ftp": true, "ftps": true}
+
+// AllowAnyScheme lets add take urls of any scheme, such as file urls reading local files, not
+// only http, https, ftp and ftps ones. It must be called before serving.
+func (s *Server) AllowAnyScheme() {
+	s.anyScheme = true
+}
+
+// Add submits j and returns the id of its download
+func (s *Server) Add(j gget.Job) string {
+	h := s.d.Submit(j)
+	s.mu.Lock()
+	s.next++
+	id := strconv.Itoa(s.next)
+	s.jobs[id] = &entry{id: id, h: h, notified: -1}
+	s.ids = append(s.ids, id)
+	s.sweep()
+	s.mu.Unlock()
+	go func() {
+		<-h.Done()
+		s.mu.Lock()
+		s.sweep()
+		s.mu.Unlock()
+	}()
+	return id
+}
+
+// Close stops taking downloads, cancels the paused ones, waits for the others and ends the
+// event streams. Cancel the context of New to stop the downloads running too.
+func (s *Server) Close() (*gget.Report, error) {
+	rep, err := s.d.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	s.closed = true
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+	return rep, err
+}
+
+// jobInfo is a download as the clients see it
+type jobInfo struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Status   string `json:"status"`
+	Priority int    `json:"priority"`
+	Name     string `json:"name,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	// Size is -1 when unknown
+	Size int64 `json:"size"`
+	// Rate is in bytes per second
+	Rate  int64  `json:"rate"`
+	ETA   string `json:"eta,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (e *entry) info() jobInfo {
+	h := e.h
+	p := h.Progress()
+	ji := jobInfo{
+		ID:       e.id,
+		URL:      h.Job.URL,
+		Status:   h.Status().String(),
+		Priority: h.Priority(),
+		Name:     p.Name,
+		Bytes:    p.Bytes,
+		Size:     p.Size,
+		Rate:     int64(p.Rate),
+	}
+	if p.ETA >= 0 {
+		ji.ETA = p.ETA.Round(time.Second).String()
+	}
+	select {
+	case <-h.Done():
+		res := h.Wait()
+		ji.Path = res.Path
+		if res.Err != nil {
+			ji.Error = res.Err.Error()
+		}
+	default:
+	}
+	return ji
+}
+
+// sweep tells the clients of the downloads whose status changed, s.mu must be held
+func (s *Server) sweep() {
+	for _, id := range s.ids {
+		e := s.jobs[id]
+		if st := e.h.Status(); st != e.notified {
+			e.notified = st
+			s.publish("job", e.info())
+		}
+	}
+}
+
+// publish sends an event to the clients, dropping those too slow to keep up, s.mu must be held
+func (s *Server) publish(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- event{name, data}:
+		default:
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+type progressEvent struct {
+	Time time.Time `json:"time"`
+	// Rate is the speed of all downloads together in bytes per second
+	Rate   int64     `json:"rate"`
+	Active []jobInfo `json:"active"`
+}
+
+// progress is the gget.ProgressFunc of the downloader
+func (s *Server) progress(p gget.Progress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	var active []jobInfo
+	for _, id := range s.ids {
+		if e := s.jobs[id]; e.h.Status() == gget.JobRunning {
+			active = append(active, e.info())
+		}
+	}
+	if len(active) > 0 {
+		s.publish("progress", progressEvent{Time: p.Time, Rate: int64(p.Rate), Active: active})
+	}
+}
+
+// subscribe returns a channel of the events from now on, starting with a job event of every
+// download, false once the server is closed
+func (s *Server) subscribe() (chan event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, false
+	}
+	ch := make(chan event, len(s.ids)+64)
+	for _, id := range s.ids {
+		if data, err := json.Marshal(s.jobs[id].info()); err == nil {
+			ch <- event{"job", data}
+		}
+	}
+	s.subs[ch] = struct{}{}
+	return ch, true
+}
+
+func (s *Server) unsubscribe(ch chan event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[ch]; ok {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+// ServeHTTP serves /jsonrpc and /events
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/jsonrpc":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveRPC(w, r)
+	case "/events":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveEvents(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, value, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return false
+		}
+		token = strings.TrimSpace(value)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch, ok := s.subscribe()
+	if !ok {
+		http.Error(w, gget.ErrClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.unsubscribe(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func invalidParams(format string, args ...interface{}) *rpcError {
+	return &rpcError{Code: codeParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// serveRPC answers a request or a batch of them, notifications are not answered
+func (s *Server) serveRPC(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequest))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var reply interface{}
+	if trimmed := bytes.TrimLeft(body, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			code := codeInvalidRequest
+			if err != nil {
+				code = codeParse
+			}
+			reply = &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: code, Message: "invalid batch"}}
+		} else {
+			var resps []*response
+			for _, raw := range batch {
+				if resp := s.call(raw); resp != nil {
+					resps = append(resps, resp)
+				}
+			}
+			if resps != nil {
+				reply = resps
+			}
+		}
+	} else if resp := s.call(body); resp != nil {
+		reply = resp
+	}
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// call runs one request and returns its response, nil for a notification
+func (s *Server) call(raw json.RawMessage) *response {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		code := codeInvalidRequest
+		if !json.Valid(raw) {
+			code = codeParse
+		}
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: code, Message: err.Error()}}
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		id := req.ID
+		if id == nil {
+			id = json.RawMessage("null")
+		}
+		return &response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: codeInvalidRequest, Message: "not a JSON-RPC 2.0 request"}}
+	}
+	result, err := s.dispatch(req.Method, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	resp := &response{JSONRPC: "2.0", ID: req.ID, Result: result}
+	if err != nil {
+		var re *rpcError
+		if !errors.As(err, &re) {
+			re = &rpcError{Code: codeJob, Message: err.Error()}
+		}
+		resp.Result, resp.Error = nil, re
+	}
+	return resp
+}
+
+type addParams struct {
+	URL      string            `json:"url"`
+	Mirrors  []string          `json:"mirrors"`
+	Output   string            `json:"output"`
+	Header   map[string]string `json:"header"`
+	Checksum string            `json:"checksum"`
+	Priority int               `json:"priority"`
+}
+
+type jobParams struct {
+	ID       string `json:"id"`
+	Priority *int   `json:"priority"`
+}
+
+type listResult struct {
+	Active  []jobInfo `json:"active"`
+	Waiting []jobInfo `json:"waiting"`
+	Stopped []jobInfo `json:"stopped"`
+}
+
+// dispatch runs method with the named params
+func (s *Server) dispatch(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "add":
+		var p addParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.URL == "" {
+			return nil, invalidParams("url is required")
+		}
+		if !s.anyScheme {
+			for _, link := range append([]string{p.URL}, p.Mirrors...) {
+				if u, err := url.Parse(link); err != nil || !networkSchemes[strings.ToLower(u.Scheme)] {
+					return nil, invalidParams("url %q is not http, https, ftp or ftps", link)
+				}
+			}
+		}
+		j := gget.Job{URL: p.URL, Mirrors: p.Mirrors, Output: p.Output, Priority: p.Priority}
+		if p.Checksum != "" {
+			c, err := gget.ParseChecksum(p.Checksum)
+			if err != nil {
+				return nil, invalidParams("%v", err)
+			}
+			j.Checksum = c
+		}
+		if len(p.Header) > 0 {
+			j.Header = http.Header{}
+			for k, v := range p.Header {
+				j.Header.Set(k, v)
+			}
+		}
+		return s.Add(j), nil
+	case "pause", "resume", "remove", "priority", "status":
+		var p jobParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		e := s.jobs[p.ID]
+		s.mu.Unlock()
+		if e == nil {
+			return nil, &rpcError{Code: codeJob, Message: fmt.Sprintf("no download %q", p.ID)}
+		}
+		var err error
+		switch method {
+		case "pause":
+			err = e.h.Pause()
+		case "resume":
+			err = e.h.Resume()
+		case "remove":
+			e.h.Cancel()
+		case "priority":
+			if p.Priority == nil {
+				return nil, invalidParams("priority is required")
+			}
+			e.h.SetPriority(*p.Priority)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.sweep()
+		s.mu.Unlock()
+		return e.info(), nil
+	case "list":
+		return s.list(), nil
+	case "purge":
+		return s.purge(), nil
+	}
+	return nil, &rpcError{Code: codeMethod, Message: fmt.Sprintf("no method %q", method)}
+}
+
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return invalidParams("params must be an object of named params: %v", err)
+	}
+	return nil
+}
+
+// list returns the downloads running, those queued or paused in the order they are taken
+// in, and those over
+func (s *Server) list() listResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := listResult{Active: []jobInfo{}, Waiting: []jobInfo{}, Stopped: []jobInfo{}}
+	for _, id := range s.ids {
+		ji := s.jobs[id].info()
+		switch ji.Status {
+		case gget.JobRunning.String():
+			l.Active = append(l.Active, ji)
+		case gget.JobQueued.String(), gget.JobPaused.String():
+			l.Waiting = append(l.Waiting, ji)
+		default:
+			l.Stopped = append(l.Stopped, ji)
+		}
+	}
+	sort.SliceStable(l.Waiting, func(i, j int) bool {
+		return l.Waiting[i].Priority > l.Waiting[j].Priority
+	})
+	return l
+}
+
+// purge forgets the downloads that are over and returns how many
+func (s *Server) purge() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := s.ids[:0]
+	n := 0
+	for _, id := range s.ids {
+		select {
+		case <-s.jobs[id].h.Done():
+			delete(s.jobs, id)
+			n++
+		default:
+			ids = append(ids, id)
+		}
+	}
+	s.ids = ids
+	return n
+}
@@ -0,0 +1,233 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"gget/gget"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var payload = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+func TestServer(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		if r.Method == http.MethodGet && r.Header.Get("Range") == "" && (name == "big.bin" || name == "stall.bin") {
+			// half the file, then nothing until the download stops
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			w.Write(payload[:len(payload)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(payload))
+	}))
+	defer files.Close()
+	dir := t.TempDir()
+	s, err := New(context.Background(), dir, "secret", gget.WithRetry(gget.RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(s)
+	defer api.Close()
+
+	call := func(token, method string, params, result interface{}) *rpcError {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 7, "method": method, "params": params})
+		req, _ := http.NewRequest(http.MethodPost, api.URL+"/jsonrpc", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &rpcError{Code: resp.StatusCode, Message: resp.Status}
+		}
+		var r struct {
+			ID     int             `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *rpcError       `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil || r.ID != 7 {
+			t.Fatalf("bad response %+v: %v", r, err)
+		}
+		if r.Error == nil && result != nil {
+			if err := json.Unmarshal(r.Result, result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r.Error
+	}
+	status := func(id string) jobInfo {
+		t.Helper()
+		var ji jobInfo
+		if err := call("secret", "status", map[string]string{"id": id}, &ji); err != nil {
+			t.Fatal(err)
+		}
+		return ji
+	}
+	await := func(id, want string) jobInfo {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			ji := status(id)
+			if ji.Status == want {
+				return ji
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("download %s is %+v, want %s", id, ji, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	add := func(params map[string]interface{}) string {
+		t.Helper()
+		var id string
+		if err := call("secret", "add", params, &id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	if err := call("wrong", "list", nil, nil); err == nil || err.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token got %v", err)
+	}
+	events, err := http.Get(api.URL + "/events?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	type sse struct {
+		name string
+		data string
+	}
+	stream := make(chan sse, 100)
+	go func() {
+		defer close(stream)
+		var name string
+		sc := bufio.NewScanner(events.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				stream <- sse{name, strings.TrimPrefix(line, "data: ")}
+			}
+		}
+	}()
+	expect := func(name string, match func(string) bool) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev, ok := <-stream:
+				if !ok {
+					t.Fatalf("events ended before a %s event", name)
+				}
+				if ev.name == name && match(ev.data) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %s event", name)
+			}
+		}
+	}
+
+	big := add(map[string]interface{}{"url": files.URL + "/big.bin"})
+	await(big, "running")
+	expect("progress", func(data string) bool {
+		var p progressEvent
+		json.Unmarshal([]byte(data), &p)
+		return len(p.Active) == 1 && p.Active[0].ID == big && p.Active[0].Bytes == int64(len(payload)/2)
+	})
+
+	// one routine is busy, the others wait by priority
+	a := add(map[string]interface{}{"url": files.URL + "/a.bin"})
+	b := add(map[string]interface{}{"url": files.URL + "/b.bin", "priority": 5})
+	if err := call("secret", "priority", map[string]interface{}{"id": a, "priority": 9}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var l listResult
+	if err := call("secret", "list", nil, &l); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Active) != 1 || l.Active[0].ID != big || len(l.Waiting) != 2 || l.Waiting[0].ID != a || l.Waiting[1].ID != b {
+		t.Fatalf("got list %+v", l)
+	}
+
+	// pausing frees the routine, resuming continues from what was received
+	if err := call("secret", "pause", map[string]string{"id": big}, nil); err != nil {
+		t.Fatal(err)
+	}
+	await(big, "paused")
+	await(a, "done")
+	await(b, "done")
+	if ji := status(big); ji.Bytes != int64(len(payload)/2) {
+		t.Errorf("paused at %+v", ji)
+	}
+	if err := call("secret", "resume", map[string]string{"id": big}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ji := await(big, "done"); ji.Path != filepath.Join(dir, "big.bin") || ji.Bytes != int64(len(payload)) {
+		t.Errorf("got %+v", ji)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "big.bin")); !bytes.Equal(b, payload) {
+		t.Error("resumed file differs")
+	}
+	expect("job", func(data string) bool {
+		return strings.Contains(data, `"id":"`+big+`"`) && strings.Contains(data, `"status":"done"`)
+	})
+
+	stall := add(map[string]interface{}{"url": files.URL + "/stall.bin"})
+	await(stall, "running")
+	if err := call("secret", "remove", map[string]string{"id": stall}, nil); err != nil {
+		t.Fatal(err)
+	}
+	await(stall, "canceled")
+	if err := call("secret", "resume", map[string]string{"id": stall}, nil); err == nil || err.Code != codeJob {
+		t.Errorf("resumed a removed download: %v", err)
+	}
+	if err := call("secret", "pause", map[string]string{"id": "nope"}, nil); err == nil || err.Code != codeJob {
+		t.Errorf("paused an unknown download: %v", err)
+	}
+	escape := add(map[string]interface{}{"url": files.URL + "/c.bin", "output": "../c.bin"})
+	if ji := await(escape, "failed"); !strings.Contains(ji.Error, "output directory") {
+		t.Errorf("got %+v", ji)
+	}
+	if err := call("secret", "add", map[string]interface{}{"url": "file:///etc/passwd"}, nil); err == nil || err.Code != codeParams {
+		t.Errorf("added a file url: %v", err)
+	}
+	if err := call("secret", "add", map[string]interface{}{"url": files.URL + "/d.bin", "mirrors": []string{"data:,x"}}, nil); err == nil || err.Code != codeParams {
+		t.Errorf("added a data mirror: %v", err)
+	}
+	if err := call("secret", "fly", nil, nil); err == nil || err.Code != codeMethod {
+		t.Errorf("unknown method got %v", err)
+	}
+	var n int
+	if err := call("secret", "purge", nil, &n); err != nil || n != 5 {
+		t.Errorf("purged %d: %v", n, err)
+	}
+
+	if err := call("secret", "list", nil, &l); err != nil || len(l.Active)+len(l.Waiting)+len(l.Stopped) != 0 {
+		t.Errorf("got list %+v after purge: %v", l, err)
+	}
+
+	s.Close()
+	for range stream {
+	}
+}
@@ -0,0 +1,145 @@
+package gget
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Checksum is the expected digest of a file
+type Checksum struct {
+	// Algo is one of md5, sha1, sha256 and sha512
+	Algo string
+	Sum  []byte
+}
+
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// algoBySize maps digest sizes in bytes to the algorithm producing them
+var algoBySize = map[int]string{
+	md5.Size:    "md5",
+	sha1.Size:   "sha1",
+	sha256.Size: "sha256",
+	sha512.Size: "sha512",
+}
+
+// NewChecksum returns the checksum of algo with the hex encoded digest
+func NewChecksum(algo, digest string) (*Checksum, error) {
+	algo = strings.ToLower(strings.ReplaceAll(algo, "-", ""))
+	newHash, ok := hashes[algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s digest %q", algo, digest)
+	}
+	if len(sum) != newHash().Size() {
+		return nil, fmt.Errorf("invalid %s digest %q, wrong length", algo, digest)
+	}
+	return &Checksum{Algo: algo, Sum: sum}, nil
+}
+
+// ParseChecksum parses a checksum written as algo=hex, as in gget input lines, or algo:hex
+func ParseChecksum(s string) (*Checksum, error) {
+	i := strings.IndexAny(s, "=:")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid checksum %q, want algo=hex", s)
+	}
+	return NewChecksum(s[:i], s[i+1:])
+}
+
+func (c *Checksum) String() string {
+	return c.Algo + "=" + hex.EncodeToString(c.Sum)
+}
+
+func (c *Checksum) hash() hash.Hash {
+	return hashes[c.Algo]()
+}
+
+// ChecksumError is returned when a downloaded file does not match its checksum, it is retryable
+type ChecksumError struct {
+	URL      string
+	Expected *Checksum
+	Actual   []byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch, expected %x but got %x", e.URL, e.Expected.Sum, e.Actual)
+}
+
+// ParseSums parses a checksum list such as SHA256SUMS, in the GNU ("<hex>  <name>") or the
+// BSD ("SHA256 (<name>) = <hex>") format, into checksums by file name. The algorithm of GNU
+// lines is told by the digest length.
+func ParseSums(r io.Reader) (map[string]*Checksum, error) {
+	sums := make(map[string]*Checksum)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var (
+			name string
+			c    *Checksum
+			err  error
+		)
+		if open := strings.Index(line, " ("); open > 0 && strings.Contains(line, ") = ") {
+			closing := strings.LastIndex(line, ") = ")
+			name = line[open+2 : closing]
+			c, err = NewChecksum(line[:open], line[closing+4:])
+		} else {
+			digest, rest, ok := strings.Cut(line, " ")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid checksum line %q", n, line)
+			}
+			// binary mode entries mark the name with a *
+			name = strings.TrimPrefix(strings.TrimLeft(rest, " "), "*")
+			algo, known := algoBySize[len(digest)/2]
+			if !known {
+				return nil, fmt.Errorf("line %d: unknown digest length %d", n, len(digest))
+			}
+			c, err = NewChecksum(algo, digest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		sums[filepath.Base(name)] = c
+	}
+	return sums, scanner.Err()
+}
+
+// verify checks the file at path against c, using the digest h already computed while
+// writing it when h is not nil
+func (c *Checksum) verify(link, path string, h hash.Hash) error {
+	if h == nil {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h = c.hash()
+		if _, err := io.Copy(h, f); err != nil {
+			return fmt.Errorf("hash %s failed with %v", path, err)
+		}
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, c.Sum) {
+		return &ChecksumError{URL: link, Expected: c, Actual: sum}
+	}
+	return nil
+}
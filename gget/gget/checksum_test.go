@@ -0,0 +1,104 @@
+package gget
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecksum(t *testing.T) {
+	good := sha256.Sum256(payload)
+	bad := sha256.Sum256([]byte("something else"))
+	policy := WithRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	t.Run("test matching checksum from input line", func(t *testing.T) {
+		srv := httptest.NewServer(serveFile("data.bin"))
+		defer srv.Close()
+		outdir := t.TempDir()
+		input := fmt.Sprintf("%s sha256=%x\n", srv.URL, good)
+		if err := Get(context.Background(), strings.NewReader(input), 1, outdir, policy); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(outdir, "data.bin")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("test mismatch is retried and quarantined", func(t *testing.T) {
+		var calls int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				calls++
+			}
+			serveFile("data.bin")(w, r)
+		}))
+		defer srv.Close()
+		outdir, quarantine := t.TempDir(), t.TempDir()
+		input := fmt.Sprintf("%s sha256=%x\n", srv.URL, bad)
+		err := Get(context.Background(), strings.NewReader(input), 1, outdir, policy, WithQuarantine(quarantine))
+		var ce *ChecksumError
+		if !errors.As(err, &ce) {
+			t.Fatalf("wanted a checksum error but got %v", err)
+		}
+		if calls != 2 {
+			t.Errorf("wanted the mismatch to be retried once, got %d calls", calls)
+		}
+		if _, err := os.Stat(filepath.Join(outdir, "data.bin")); err == nil {
+			t.Errorf("mismatching file should not be kept")
+		}
+		if _, err := os.Stat(filepath.Join(quarantine, "data.bin")); err != nil {
+			t.Errorf("mismatching file should be quarantined: %v", err)
+		}
+	})
+
+	t.Run("test sums file", func(t *testing.T) {
+		sums, err := ParseSums(strings.NewReader(fmt.Sprintf("%x  data.bin\n%x *other.bin\nSHA256 (third.bin) = %x\n", good, bad, bad)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sums) != 3 || sums["other.bin"] == nil || sums["third.bin"] == nil {
+			t.Fatalf("unexpected sums %v", sums)
+		}
+		defer func(n int64) { minSegmentSize = n }(minSegmentSize)
+		minSegmentSize = 8 << 10
+		srv := httptest.NewServer(serveFile("data.bin"))
+		defer srv.Close()
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 4, t.TempDir(), WithChecksums(sums)); err != nil {
+			t.Fatal(err)
+		}
+		sums["data.bin"] = sums["other.bin"]
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 4, t.TempDir(), WithChecksums(sums), policy); err == nil {
+			t.Errorf("expected a checksum error for the segmented download")
+		}
+	})
+
+	t.Run("test sums file by the name the server gives", func(t *testing.T) {
+		srv := httptest.NewServer(serveFile("data.bin"))
+		defer srv.Close()
+		outdir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(outdir, "data.bin"), []byte("older"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sums := map[string]*Checksum{"data.bin": {Algo: "sha256", Sum: bad[:]}}
+		err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, outdir, WithChecksums(sums), WithConflict(ConflictRename))
+		var ce *ChecksumError
+		if !errors.As(err, &ce) {
+			t.Fatalf("wanted a checksum error for the renamed file but got %v", err)
+		}
+		sums["data.bin"] = &Checksum{Algo: "sha256", Sum: good[:]}
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, outdir, WithChecksums(sums), WithConflict(ConflictRename)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(outdir, "data-1.bin")); err != nil {
+			t.Error(err)
+		}
+	})
+}
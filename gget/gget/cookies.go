@@ -0,0 +1,239 @@
+package gget
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies in cookies.txt files, as curl writes them
+const httpOnlyPrefix = "#HttpOnly_"
+
+// cookie is a cookie as stored in a cookies.txt file
+type cookie struct {
+	domain   string
+	hostOnly bool
+	path     string
+	secure   bool
+	httpOnly bool
+	// expires is zero for a session cookie
+	expires     time.Time
+	name, value string
+}
+
+func (c *cookie) expired(now time.Time) bool {
+	return !c.expires.IsZero() && !c.expires.After(now)
+}
+
+// cookieJar is an http.CookieJar kept in a Netscape cookies.txt file
+type cookieJar struct {
+	mu      sync.Mutex
+	cookies []*cookie
+}
+
+// loadCookies reads the cookies.txt file at path, a missing file is an empty jar
+func loadCookies(path string) (*cookieJar, error) {
+	j := &cookieJar{}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		var httpOnly bool
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			line, httpOnly = strings.TrimPrefix(line, httpOnlyPrefix), true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%s:%d: want 7 tab separated fields but got %d", path, n, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid expiry %q", path, n, fields[4])
+		}
+		c := &cookie{
+			domain:   strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+			hostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			path:     fields[2],
+			secure:   strings.EqualFold(fields[3], "TRUE"),
+			httpOnly: httpOnly,
+			name:     fields[5],
+			value:    fields[6],
+		}
+		if expires > 0 {
+			c.expires = time.Unix(expires, 0)
+		}
+		j.cookies = append(j.cookies, c)
+	}
+	return j, scanner.Err()
+}
+
+// save writes the cookies that have not expired to path
+func (j *cookieJar) save(path string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n")
+	now := time.Now()
+	for _, c := range j.cookies {
+		if c.expired(now) {
+			continue
+		}
+		domain := c.domain
+		if !c.hostOnly {
+			domain = "." + domain
+		}
+		if c.httpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !c.expires.IsZero() {
+			expires = c.expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, upperBool(!c.hostOnly), c.path, upperBool(c.secure), expires, c.name, c.value)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func upperBool(v bool) string {
+	if v {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// defaultPath is the path of a cookie set by u without one, as in RFC 6265
+func defaultPath(u *url.URL) string {
+	i := strings.LastIndex(u.Path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return u.Path[:i]
+}
+
+// SetCookies stores the cookies u sets whose domain u belongs to
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	host := strings.ToLower(u.Hostname())
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, hc := range cookies {
+		c := &cookie{domain: host, hostOnly: true, path: hc.Path, secure: hc.Secure, httpOnly: hc.HttpOnly, name: hc.Name, value: hc.Value}
+		if hc.Domain != "" {
+			d := strings.ToLower(strings.TrimPrefix(hc.Domain, "."))
+			ip := net.ParseIP(host) != nil
+			switch {
+			case d == host && (ip || publicSuffix(d)):
+				// kept for the host alone, not for every name under it
+			case host != d && !strings.HasSuffix(host, "."+d), ip, publicSuffix(d):
+				continue
+			default:
+				c.domain, c.hostOnly = d, false
+			}
+		}
+		if !strings.HasPrefix(c.path, "/") {
+			c.path = defaultPath(u)
+		}
+		switch {
+		case hc.MaxAge < 0:
+			c.expires = now
+		case hc.MaxAge > 0:
+			c.expires = now.Add(time.Duration(hc.MaxAge) * time.Second)
+		case !hc.Expires.IsZero():
+			c.expires = hc.Expires
+		}
+		j.replace(c, now)
+	}
+}
+
+// registryLabels are second level labels countries register names under, as in co.uk or com.au
+var registryLabels = map[string]bool{
+	"ac": true, "co": true, "com": true, "edu": true, "go": true, "gob": true, "gov": true,
+	"gv": true, "ltd": true, "me": true, "mil": true, "ne": true, "net": true, "nic": true,
+	"nom": true, "or": true, "org": true, "plc": true, "sch": true,
+}
+
+// publicSuffix reports whether names are registered under domain by anybody, so a cookie must
+// not be set for all of them: a single label such as com, or a registry label under a country
+// code such as co.uk. Without the public suffix list this only catches the common cases.
+func publicSuffix(domain string) bool {
+	labels := strings.Split(domain, ".")
+	switch len(labels) {
+	case 1:
+		return true
+	case 2:
+		return len(labels[1]) == 2 && registryLabels[labels[0]]
+	}
+	return false
+}
+
+// replace stores c in place of the cookie of the same domain, path and name, j.mu must be held
+func (j *cookieJar) replace(c *cookie, now time.Time) {
+	kept := j.cookies[:0]
+	for _, old := range j.cookies {
+		if old.domain == c.domain && old.path == c.path && old.name == c.name {
+			continue
+		}
+		kept = append(kept, old)
+	}
+	j.cookies = kept
+	if !c.expired(now) {
+		j.cookies = append(j.cookies, c)
+	}
+}
+
+// Cookies returns the cookies to send to u
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	host := strings.ToLower(u.Hostname())
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var cookies []*http.Cookie
+	for _, c := range j.cookies {
+		if c.expired(now) || c.secure && u.Scheme != "https" {
+			continue
+		}
+		if c.hostOnly && host != c.domain || !c.hostOnly && host != c.domain && !strings.HasSuffix(host, "."+c.domain) {
+			continue
+		}
+		if !pathMatch(c.path, p) {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{Name: c.name, Value: c.value})
+	}
+	return cookies
+}
+
+// pathMatch reports whether a cookie of path cp is sent for the request path p
+func pathMatch(cp, p string) bool {
+	if !strings.HasPrefix(p, cp) {
+		return false
+	}
+	return len(p) == len(cp) || strings.HasSuffix(cp, "/") || p[len(cp)] == '/'
+}
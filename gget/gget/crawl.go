@@ -0,0 +1,302 @@
+package gget
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Scope is which links found while crawling are followed, relative to the input url they
+// were reached from
+type Scope int
+
+const (
+	// ScopeHost follows links to the same host
+	ScopeHost Scope = iota
+	// ScopeDomain follows links to the same host and its subdomains, ignoring a leading www.
+	ScopeDomain
+	// ScopePrefix follows links to the same host under the directory of the input url
+	ScopePrefix
+)
+
+var scopeNames = []string{"host", "domain", "prefix"}
+
+func (s Scope) String() string {
+	if s < 0 || int(s) >= len(scopeNames) {
+		return fmt.Sprintf("Scope(%d)", int(s))
+	}
+	return scopeNames[s]
+}
+
+// ParseScope parses host, domain or prefix
+func ParseScope(s string) (Scope, error) {
+	for i, name := range scopeNames {
+		if s == name {
+			return Scope(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown scope %q, expected one of %s", s, strings.Join(scopeNames, ", "))
+}
+
+// Crawl configures recursive downloads. The html pages downloaded, and stylesheets with CSS,
+// are searched for links which are downloaded in turn, saved in a directory tree in outdir
+// mirroring their host and path. Failures of the links found never stop the batch, they are
+// in the report.
+type Crawl struct {
+	// Depth is how many links away from the input urls are followed, 0 means no limit
+	Depth int
+	Scope Scope
+	// Include, if set, must match a link found for it to be followed
+	Include []*regexp.Regexp
+	// Exclude must not match a link found for it to be followed
+	Exclude []*regexp.Regexp
+	// IgnoreRobots follows links robots.txt disallows
+	IgnoreRobots bool
+	// CSS searches stylesheets for links too
+	CSS bool
+	// ConvertLinks rewrites the links of the saved pages once the batch is done, to the local
+	// files for the links downloaded and to absolute urls for the others, for offline browsing
+	ConvertLinks bool
+}
+
+// crawler follows the links of the pages of a Fetch
+type crawler struct {
+	Crawl
+	robots robotsCache
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newCrawler(c Crawl) *crawler {
+	return &crawler{
+		Crawl:  c,
+		robots: robotsCache{hosts: make(map[string]*robotsEntry)},
+		seen:   make(map[string]bool),
+	}
+}
+
+// normalize returns link without its fragment, nil when it is not an http url
+func normalize(u *url.URL) *url.URL {
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil
+	}
+	v := *u
+	v.Fragment, v.RawFragment = "", ""
+	return &v
+}
+
+// visit reports whether u is seen for the first time
+func (c *crawler) visit(u string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[u] {
+		return false
+	}
+	c.seen[u] = true
+	return true
+}
+
+// inScope reports whether u is within the scope of the input url root
+func (c *crawler) inScope(root, u *url.URL) bool {
+	switch c.Scope {
+	case ScopeDomain:
+		h, d := strings.ToLower(u.Hostname()), strings.TrimPrefix(strings.ToLower(root.Hostname()), "www.")
+		return h == d || strings.HasSuffix(h, "."+d)
+	case ScopePrefix:
+		dir := root.Path[:strings.LastIndex(root.Path, "/")+1]
+		return strings.EqualFold(u.Host, root.Host) && strings.HasPrefix(u.Path, dir)
+	}
+	return strings.EqualFold(u.Host, root.Host)
+}
+
+// filtered reports whether u passes the include and exclude patterns
+func (c *crawler) filtered(u string) bool {
+	for _, re := range c.Exclude {
+		if re.MatchString(u) {
+			return false
+		}
+	}
+	if len(c.Include) == 0 {
+		return true
+	}
+	for _, re := range c.Include {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageLinks returns the links of the page saved at p, nil when it is not a page to search
+func (c *crawler) pageLinks(p string) (data []byte, links []link, base string, err error) {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".html", ".htm":
+	case ".css":
+		if !c.CSS {
+			return nil, nil, "", nil
+		}
+	default:
+		return nil, nil, "", nil
+	}
+	data, err = os.ReadFile(p)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if strings.EqualFold(filepath.Ext(p), ".css") {
+		return data, cssLinks(data, 0), "", nil
+	}
+	links, base = htmlLinks(data, c.CSS)
+	return data, links, base, nil
+}
+
+// resolve returns the normalized url of ref in the page at link with the given base
+func resolve(link, base, ref string) *url.URL {
+	page, err := url.Parse(link)
+	if err != nil {
+		return nil
+	}
+	if base != "" {
+		if b, err := page.Parse(base); err == nil {
+			page = b
+		}
+	}
+	u, err := page.Parse(ref)
+	if err != nil {
+		return nil
+	}
+	return normalize(u)
+}
+
+// follow queues the links of the page jb was saved to at p
+func (c *crawler) follow(ctx context.Context, b *batch, jb job, p string) error {
+	if jb.root == nil || c.Depth > 0 && jb.depth >= c.Depth {
+		return nil
+	}
+	_, links, base, err := c.pageLinks(p)
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		u := resolve(jb.URL, base, l.ref)
+		if u == nil || !c.inScope(jb.root, u) || !c.filtered(u.String()) {
+			continue
+		}
+		if !c.IgnoreRobots && !c.robots.get(ctx, b, u).allowed(u) {
+			continue
+		}
+		if !c.visit(u.String()) {
+			continue
+		}
+		b.add(job{Job: Job{URL: u.String(), Priority: jb.Priority}, depth: jb.depth + 1, root: jb.root})
+	}
+	return nil
+}
+
+// treePath returns the path within outdir a crawled url is saved at, host/path with
+// index.html for directories, the query after an @ and .html added to html pages lacking it
+func treePath(u *url.URL, contentType string) string {
+	elems := []string{sanitize(u.Host)}
+	if elems[0] == "" {
+		elems[0] = "host"
+	}
+	for _, e := range strings.Split(path.Clean("/"+u.Path), "/") {
+		if e = sanitize(e); e != "" {
+			elems = append(elems, e)
+		}
+	}
+	if len(elems) == 1 || strings.HasSuffix(u.Path, "/") {
+		elems = append(elems, "index.html")
+	}
+	name := elems[len(elems)-1]
+	if u.RawQuery != "" {
+		name += "@" + sanitize(strings.ReplaceAll(u.RawQuery, "/", "%2F"))
+	}
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil && mt == "text/html" {
+		if ext := strings.ToLower(path.Ext(name)); ext != ".html" && ext != ".htm" {
+			name += ".html"
+		}
+	}
+	elems[len(elems)-1] = name
+	return filepath.Join(elems...)
+}
+
+// convert rewrites the links of the pages in rep to the files they were saved to, or to
+// absolute urls when they were not downloaded
+func (c *crawler) convert(rep *Report) error {
+	saved := make(map[string]string)
+	for _, res := range rep.Results {
+		if res.Path == "" {
+			continue
+		}
+		if u, err := url.Parse(res.URL); err == nil {
+			if u = normalize(u); u != nil {
+				saved[u.String()] = res.Path
+			}
+		}
+	}
+	for _, res := range rep.Results {
+		if res.Path == "" {
+			continue
+		}
+		data, links, base, err := c.pageLinks(res.Path)
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			continue
+		}
+		isHTML := !strings.EqualFold(filepath.Ext(res.Path), ".css")
+		var out strings.Builder
+		last := 0
+		for _, l := range links {
+			u := resolve(res.URL, base, l.ref)
+			if u == nil {
+				continue
+			}
+			ref := u.String()
+			if target, ok := saved[ref]; ok {
+				rel, err := filepath.Rel(filepath.Dir(res.Path), target)
+				if err != nil {
+					continue
+				}
+				ref = (&url.URL{Path: filepath.ToSlash(rel)}).String()
+			}
+			if frag := fragment(l.ref); frag != "" {
+				ref += "#" + frag
+			}
+			if isHTML {
+				ref = html.EscapeString(ref)
+			}
+			out.Write(data[last:l.start])
+			out.WriteString(ref)
+			last = l.end
+		}
+		out.Write(data[last:])
+		// replaced rather than written through, the page may be a link into a store
+		tmp := res.Path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(out.String()), 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, res.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fragment returns the fragment of the reference ref
+func fragment(ref string) string {
+	if _, frag, ok := strings.Cut(ref, "#"); ok {
+		return frag
+	}
+	return ""
+}
@@ -0,0 +1,196 @@
+package gget
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCrawl(t *testing.T) {
+	pages := map[string]string{
+		"/": `<html><head><link rel="stylesheet" href="/style.css"><!-- <a href="/commented.html"> --></head>
+<body><a href="docs/">docs</a> <a href='/docs/a.html#part'>a</a> <img src="/img/logo.png" srcset="/img/logo.png 1x, /img/logo2x.png 2x">
+<a href="https://other.example/x">elsewhere</a> <a href="mailto:someone@example.com">mail</a>
+<a href="/private/secret.html">secret</a> <a href="/file.zip">zip</a> <a href="/missing.html">gone</a></body></html>`,
+		"/docs/":               `<p><a href="../deep.html">deep</a></p>`,
+		"/docs/a.html":         `<script>var s = "<a href='/script.html'>";</script>a`,
+		"/deep.html":           `<a href="/deeper.html">deeper</a>`,
+		"/deeper.html":         `too deep`,
+		"/style.css":           `body { background: url( "bg.png" ) } /* url(/commented.png) */`,
+		"/bg.png":              `png`,
+		"/img/logo.png":        `png`,
+		"/img/logo2x.png":      `png`,
+		"/private/secret.html": `secret`,
+		"/file.zip":            `zip`,
+		"/robots.txt":          "User-agent: *\nDisallow: /private/\n",
+	}
+	var (
+		mu        sync.Mutex
+		requested = map[string]bool{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".css"):
+			w.Header().Set("Content-Type", "text/css")
+		case strings.HasSuffix(r.URL.Path, "/"), strings.HasSuffix(r.URL.Path, ".html"):
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	crawl := Crawl{
+		Depth:        2,
+		Exclude:      []*regexp.Regexp{regexp.MustCompile(`\.zip$`)},
+		CSS:          true,
+		ConvertLinks: true,
+	}
+	rep, err := Fetch(context.Background(), strings.NewReader(srv.URL+"/\n"), 3, dir, WithCrawl(crawl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Failed() != 1 {
+		t.Errorf("expected only the missing page to fail but got %d failures", rep.Failed())
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	for _, name := range []string{"index.html", "docs/index.html", "docs/a.html", "deep.html", "style.css", "bg.png", "img/logo.png", "img/logo2x.png"} {
+		if _, err := os.Stat(filepath.Join(dir, host, name)); err != nil {
+			t.Errorf("expected %s to be saved: %v", name, err)
+		}
+	}
+	for _, p := range []string{"/deeper.html", "/private/secret.html", "/file.zip", "/commented.html", "/script.html", "/commented.png"} {
+		if requested[p] {
+			t.Errorf("expected %s not to be requested", p)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, host, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`href="style.css"`,
+		`href="docs/index.html"`,
+		`href='docs/a.html#part'`,
+		`srcset="img/logo.png 1x, img/logo2x.png 2x"`,
+		`href="https://other.example/x"`,
+		`href="mailto:someone@example.com"`,
+		`href="` + srv.URL + `/missing.html"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected the converted page to contain %s", want)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, host, "docs/index.html")); !strings.Contains(string(b), `href="../deep.html"`) {
+		t.Errorf("expected a relative link to the parent directory but got %s", b)
+	}
+
+	t.Run("test urls of other schemes are not crawled", func(t *testing.T) {
+		page := "data:text/html," + url.PathEscape(`<a href="`+srv.URL+`/deep.html">deep</a>`)
+		rep, err := Fetch(context.Background(), strings.NewReader(page+"\n"), 1, t.TempDir(), WithCrawl(Crawl{Depth: 2}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rep.Results) != 1 || rep.Failed() != 0 {
+			t.Errorf("expected only the data url to be downloaded but got %+v", rep.Results)
+		}
+	})
+
+	t.Run("test robots failures", func(t *testing.T) {
+		// robots answers the requests for robots.txt in turn, the last one from then on
+		serve := func(robots ...int) (*httptest.Server, *int, map[string]bool) {
+			var (
+				mu   sync.Mutex
+				n    int
+				seen = map[string]bool{}
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				seen[r.URL.Path] = true
+				if r.URL.Path == "/robots.txt" {
+					status := robots[len(robots)-1]
+					if n < len(robots) {
+						status = robots[n]
+					}
+					n++
+					w.WriteHeader(status)
+					return
+				}
+				w.Header().Set("Content-Type", "text/html")
+				io.WriteString(w, `<a href="/linked`+r.URL.Path+`">x</a>`)
+			}))
+			return srv, &n, seen
+		}
+		for status, follow := range map[int]bool{http.StatusNotFound: true, http.StatusForbidden: true, http.StatusServiceUnavailable: false} {
+			srv, _, seen := serve(status)
+			if _, err := Fetch(context.Background(), strings.NewReader(srv.URL+"/one.html\n"), 1, t.TempDir(), WithCrawl(Crawl{Depth: 1})); err != nil {
+				t.Fatal(err)
+			}
+			if seen["/linked/one.html"] != follow {
+				t.Errorf("robots.txt status %d: expected following links %v", status, follow)
+			}
+			srv.Close()
+		}
+
+		// an unavailable robots.txt is asked for again, one that was answered is not
+		srv, n, seen := serve(http.StatusServiceUnavailable, http.StatusNotFound)
+		defer srv.Close()
+		in := srv.URL + "/one.html\n" + srv.URL + "/two.html\n" + srv.URL + "/three.html\n"
+		if _, err := Fetch(context.Background(), strings.NewReader(in), 1, t.TempDir(), WithCrawl(Crawl{Depth: 1})); err != nil {
+			t.Fatal(err)
+		}
+		if seen["/linked/one.html"] || !seen["/linked/two.html"] || !seen["/linked/three.html"] || *n != 2 {
+			t.Errorf("expected robots.txt to be asked for twice and the later links followed but got %d requests and %v", *n, seen)
+		}
+	})
+
+	t.Run("test robots rules", func(t *testing.T) {
+		r, err := parseRobots(strings.NewReader("User-agent: other\nDisallow: /\n\nUser-agent: *\nDisallow: /a\nAllow: /a/open\nDisallow: /*.pdf$\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p, want := range map[string]bool{"/": true, "/a": false, "/a/x": false, "/a/open/x": true, "/b.pdf": false, "/b.pdf?x": true} {
+			u, _ := url.Parse("http://h" + p)
+			if got := r.allowed(u); got != want {
+				t.Errorf("%s: wanted %v but got %v", p, want, got)
+			}
+		}
+	})
+
+	t.Run("test robots agent matched by product token", func(t *testing.T) {
+		for in, want := range map[string]bool{
+			"User-agent: g\nDisallow: /\n":                       true,
+			"User-agent:\nDisallow: /\n":                         true,
+			"User-agent: ggetter\nDisallow: /\n":                 true,
+			"User-agent: GGET/2.1\nDisallow: /\n":                false,
+			"User-agent: other\nUser-agent: gget\nDisallow: /\n": false,
+		} {
+			r, err := parseRobots(strings.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse("http://h/x")
+			if got := r.allowed(u); got != want {
+				t.Errorf("%q: wanted %v but got %v", in, want, got)
+			}
+		}
+	})
+}
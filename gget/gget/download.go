@@ -0,0 +1,432 @@
+package gget
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errIncomplete = errors.New("incomplete download")
+
+// batch holds what the downloads of one Fetch share
+type batch struct {
+	cfg    *config
+	cli    *http.Client
+	tls    *tls.Config
+	outdir string
+	sem    slots
+	limit  *rateLimiter
+	sched  *scheduler
+	claims claims
+	mirror *mirror
+	crawl  *crawler
+	speeds speeds
+	store  *store
+	// add queues the links found while crawling
+	add func(job)
+}
+
+// download fetches j into outdir from its url or one of its mirrors, trying them in turn until
+// one succeeds, holding a slot of sem for the whole time
+func (b *batch) download(ctx context.Context, j Job, res *Result) error {
+	if b.mirror == nil {
+		// mirror mode asks the server whether the url changed instead
+		if ok, err := b.fromStore(ctx, j, res); ok {
+			return err
+		}
+	}
+	var errs []error
+	for _, src := range b.speeds.order(j, b.cfg.mirrorOrder) {
+		if err := b.connect(ctx, res, hostOf(src)); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		res.Source = src
+		start := time.Now()
+		err := b.downloadFrom(ctx, j.from(src), res)
+		if err == nil {
+			if res.Skipped {
+				return nil
+			}
+			b.speeds.record(src, res.Bytes, time.Since(start))
+			if err := b.store.put(j, res); err != nil {
+				return err
+			}
+			return b.extract(ctx, res)
+		}
+		errs = append(errs, err)
+		if !failover(err) || ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return &MirrorsError{URL: j.URL, Errs: errs}
+}
+
+// connect moves the connection of a host res holds to host, the one contacted next. Waiting for
+// a connection of host gives up the slot of sem meanwhile, as the routines holding the
+// connections of host may need it to finish.
+func (b *batch) connect(ctx context.Context, res *Result, host string) error {
+	if res.held && res.host == host {
+		return nil
+	}
+	if res.held {
+		b.sched.done(res.host)
+		res.held = false
+	}
+	if b.sched.tryAcquire(host) {
+		res.host, res.held = host, true
+		return nil
+	}
+	b.sem.release()
+	err := b.sched.acquire(ctx, host)
+	// the slot is given back by the caller whatever happens
+	b.sem.acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	res.host, res.held = host, true
+	return nil
+}
+
+// extract unpacks the downloaded file of res when extraction is on and it is an archive,
+// removing it afterwards if asked to
+func (b *batch) extract(ctx context.Context, res *Result) error {
+	if !b.cfg.extract {
+		return nil
+	}
+	k, dest := archiveOf(res.Path)
+	if k == nil {
+		return nil
+	}
+	dest, skip, err := b.extractTarget(dest, res.index)
+	if err != nil {
+		return err
+	}
+	if skip {
+		// the archive is kept too, it is all there is of the download
+		return nil
+	}
+	if err := extract(ctx, res.Path, k, dest); err != nil {
+		return err
+	}
+	res.Extracted = dest
+	if b.cfg.removeArchive {
+		return os.Remove(res.Path)
+	}
+	return nil
+}
+
+// downloadFrom fetches j from its url, with the fetcher registered for its scheme if any. Over
+// http it is fetched in concurrent segments when the server supports ranges and the file is
+// large enough. In mirror mode a url downloaded before is only fetched again when the server
+// reports it changed.
+func (b *batch) downloadFrom(ctx context.Context, j Job, res *Result) error {
+	if f, u := b.fetcherFor(j.URL); f != nil {
+		return b.downloadFetched(ctx, j, f, u, res)
+	}
+	prev := b.mirror.lookup(j.URL)
+	var cond http.Header
+	if prev != nil {
+		cond = prev.conditions()
+	}
+	if b.cfg.body != nil {
+		// a POST is neither probed nor fetched in ranges
+		return b.downloadStream(ctx, j, prev, cond, nil, res)
+	}
+	head, ok := b.probe(ctx, j.URL, b.jobHeader(j, res.origin, j.URL, cond))
+	if head != nil && head.StatusCode == http.StatusNotModified && prev != nil {
+		return b.mirror.notModified(prev, head, res)
+	}
+	if ok {
+		err := b.downloadSegmented(ctx, j, head, res)
+		if !errors.Is(err, errRangeUnsupported) {
+			return err
+		}
+	}
+	return b.downloadStream(ctx, j, prev, cond, head, res)
+}
+
+// downloadStream fetches j over a single connection. The body is written to <name>.part next
+// to a sidecar recording its progress, an interrupted download is resumed with a range request
+// on the next run and the part file is renamed to <name> only once it is complete. The name of
+// the part to resume is told by the probed head response, if any. Without a part the first
+// request carries the cond headers of the mirrored file prev, if any.
+func (b *batch) downloadStream(ctx context.Context, j Job, prev *mirrorEntry, cond http.Header, head *http.Response, res *Result) error {
+	link := j.URL
+	var header http.Header
+	if head != nil && head.StatusCode != http.StatusNotModified {
+		header = head.Header
+	}
+	resp, ps, part, err := b.resumeStream(ctx, j, res.origin, cond, header)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		if resp, err = b.open(ctx, link, b.jobHeader(j, res.origin, link, cond)); err != nil {
+			return err
+		}
+	}
+	defer func() { resp.Body.Close() }()
+	res.StatusCode = resp.StatusCode
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		return b.mirror.notModified(prev, resp, res)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newStatusError(link, resp)
+	}
+
+	path, skip, err := b.target(j, resp.Header, res)
+	if err != nil {
+		return err
+	}
+	if ps != nil && path+partExt != part {
+		// the rest is of a part saved under another name than this response gets, start over
+		resp.Body.Close()
+		ps = nil
+		if resp, err = b.open(ctx, link, b.jobHeader(j, res.origin, link, cond)); err != nil {
+			return err
+		}
+		res.StatusCode = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return newStatusError(link, resp)
+		}
+		if path, skip, err = b.target(j, resp.Header, res); err != nil {
+			return err
+		}
+	}
+	if skip {
+		return skipped(path, res)
+	}
+	st := &partState{
+		URL:          link,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Size:         resp.ContentLength,
+	}
+	encoded := gzipEncoded(resp)
+	if encoded {
+		// the size is unknown and ranges would be of the encoded body
+		st.Size = -1
+	}
+	var offset int64
+	if ps != nil {
+		first, size, err := contentRange(resp.Header.Get("Content-Range"))
+		if err != nil || first != ps.Received {
+			return fmt.Errorf("%w of %s, resume got content range %q", errIncomplete, link, resp.Header.Get("Content-Range"))
+		}
+		offset = first
+		st.ETag, st.LastModified, st.Size = ps.ETag, ps.LastModified, size
+	}
+	var body io.Reader = resp.Body
+	if encoded {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("download %s failed with %w", link, err)
+		}
+		body = zr
+	}
+	return b.receive(ctx, j, path, st, offset, body, res)
+}
+
+// resumeStream asks for the rest of the part an earlier run left of j, named as the response
+// with header would be. A 206 response is returned with the state of the part, a 200 one, sent
+// when the part is stale or the server ignores ranges, without it. A nil response means there
+// is nothing to resume or the server refused the range, the full body must be asked for.
+func (b *batch) resumeStream(ctx context.Context, j Job, origin string, cond, header http.Header) (*http.Response, *partState, string, error) {
+	link := j.URL
+	if b.cfg.body != nil || cond != nil {
+		return nil, nil, "", nil
+	}
+	name, err := b.outputName(j, header)
+	if err != nil {
+		return nil, nil, "", nil
+	}
+	part := filepath.Join(b.outdir, name) + partExt
+	ps, err := loadPartState(part)
+	if err != nil || ps.Segments != nil || ps.Received <= 0 || ps.URL != link || ps.validator() == "" {
+		return nil, nil, "", nil
+	}
+	if fi, err := os.Stat(part); err != nil || fi.Size() < ps.Received {
+		return nil, nil, "", nil
+	}
+	resp, err := b.send(ctx, link, b.jobHeader(j, origin, link, http.Header{
+		"Range":    {fmt.Sprintf("bytes=%d-", ps.Received)},
+		"If-Range": {ps.validator()},
+	}))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && !gzipEncoded(resp):
+		return resp, ps, part, nil
+	case resp.StatusCode == http.StatusOK:
+		return resp, nil, "", nil
+	}
+	// e.g. 416 when the part is longer than the file now is
+	resp.Body.Close()
+	return nil, nil, "", nil
+}
+
+// gzipEncoded reports whether the body of resp is gzip encoded and was not decoded by the
+// transport, which only does so when it asked for the encoding itself. A gzip file served with
+// a gzip Content-Encoding, a common server mistake, is kept as it is.
+func gzipEncoded(resp *http.Response) bool {
+	if resp.Uncompressed {
+		return false
+	}
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+	default:
+		return false
+	}
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch ct {
+	case "application/gzip", "application/x-gzip", "application/x-gzip-compressed", "application/x-tgz":
+		return false
+	}
+	return true
+}
+
+// receive writes body, the content of j from offset on, to the part file of path, which holds
+// what an earlier run received before offset, and completes it once it has the size of st
+func (b *batch) receive(ctx context.Context, j Job, path string, st *partState, offset int64, body io.Reader, res *Result) error {
+	link := j.URL
+	part := path + partExt
+	st.Received = offset
+	res.tr.begin(path, offset, st.Size)
+
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("file create %s failed with %v", part, err)
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return fmt.Errorf("file truncate %s failed with %v", part, err)
+	}
+	// hash while streaming, starting with what an earlier run received
+	var h hash.Hash
+	checksum := b.checksum(j, path, res)
+	if checksum != nil {
+		h = checksum.hash()
+		if _, err := io.Copy(h, io.NewSectionReader(f, 0, offset)); err != nil {
+			return fmt.Errorf("hash %s failed with %v", part, err)
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("file seek %s failed with %v", part, err)
+	}
+	if err := st.save(part); err != nil {
+		return fmt.Errorf("part state %s save failed with %v", part, err)
+	}
+
+	cw := &checkpointWriter{f: f, part: part, st: st}
+	var w io.Writer = cw
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+	if res.tr != nil {
+		w = io.MultiWriter(w, res.tr)
+	}
+	_, err = io.Copy(w, b.limit.reader(ctx, link, body))
+	if cerr := cw.checkpoint(); err == nil {
+		err = cerr
+	}
+	res.Bytes = st.Received
+	if err != nil {
+		return fmt.Errorf("download %s failed with %w", link, err)
+	}
+	if st.Size >= 0 && st.Received != st.Size {
+		return fmt.Errorf("%w of %s, received %d of %d bytes", errIncomplete, link, st.Received, st.Size)
+	}
+	return b.complete(j, f, part, path, checksum, h, st, res)
+}
+
+// checksum returns the expected checksum of the file j is saved to at path, if any. Checksums
+// by name are looked up by the name the server gives the file, then by the name it is saved as.
+func (b *batch) checksum(j Job, path string, res *Result) *Checksum {
+	if j.Checksum != nil {
+		return j.Checksum
+	}
+	if c, ok := b.cfg.sums[res.remote]; ok {
+		return c
+	}
+	return b.cfg.sums[filepath.Base(path)]
+}
+
+// complete verifies the finished part file f against checksum, using the digest h computed
+// while writing when it is not nil, and renames it to path, recording the validators of st in
+// mirror mode. A mismatching file is removed, or moved to the quarantine directory, so the
+// next attempt starts over.
+func (b *batch) complete(j Job, f *os.File, part, path string, checksum *Checksum, h hash.Hash, st *partState, res *Result) error {
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("file close %s failed with %v", part, err)
+	}
+	if checksum != nil {
+		if err := checksum.verify(j.URL, part, h); err != nil {
+			if rerr := b.discard(part, path); rerr != nil {
+				return fmt.Errorf("%v, discarding it failed with %v", err, rerr)
+			}
+			return err
+		}
+	}
+	if err := os.Rename(part, path); err != nil {
+		return err
+	}
+	res.Path = path
+	if err := removePartState(part); err != nil {
+		return err
+	}
+	return b.mirror.record(j.URL, path, st)
+}
+
+// discard removes a part file that failed verification, or moves it to the quarantine directory
+func (b *batch) discard(part, path string) error {
+	if err := removePartState(part); err != nil {
+		return err
+	}
+	if b.cfg.quarantine == "" {
+		return os.Remove(part)
+	}
+	if err := os.MkdirAll(b.cfg.quarantine, 0o755); err != nil {
+		return err
+	}
+	return os.Rename(part, filepath.Join(b.cfg.quarantine, filepath.Base(path)))
+}
+
+// send performs a GET of link with the extra header
+func (b *batch) send(ctx context.Context, link string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return b.do(req)
+}
+
+// do sends req, prepared with the configured headers and credentials, once the politeness
+// delay of its host allows
+func (b *batch) do(req *http.Request) (*http.Response, error) {
+	b.prepare(req)
+	if err := b.sched.wait(req.Context(), strings.ToLower(req.URL.Host)); err != nil {
+		return nil, err
+	}
+	if d := b.cfg.transport.IdleTimeout; d > 0 {
+		return watchIdle(req, d, b.cli.Do)
+	}
+	return b.cli.Do(req)
+}
@@ -0,0 +1,643 @@
+package gget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrClosed is the error of a job submitted to a closed Downloader
+var ErrClosed = errors.New("downloader closed")
+
+// ErrJobOver is the error of pausing or resuming a job that finished, failed or was canceled
+var ErrJobOver = errors.New("job is over")
+
+// JobStatus is where a submitted job is at
+type JobStatus int
+
+const (
+	// JobQueued waits for a routine
+	JobQueued JobStatus = iota
+	// JobRunning is being downloaded
+	JobRunning
+	// JobDone was downloaded
+	JobDone
+	// JobFailed could not be downloaded
+	JobFailed
+	// JobCanceled was canceled before it finished
+	JobCanceled
+	// JobPaused waits to be resumed
+	JobPaused
+)
+
+var jobStatusNames = []string{"queued", "running", "done", "failed", "canceled", "paused"}
+
+func (s JobStatus) String() string {
+	if s < 0 || int(s) >= len(jobStatusNames) {
+		return fmt.Sprintf("JobStatus(%d)", int(s))
+	}
+	return jobStatusNames[s]
+}
+
+// JobHandle follows a job submitted to a Downloader
+type JobHandle struct {
+	Job Job
+
+	// d is nil for a job that was never queued
+	d      *Downloader
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	status   JobStatus
+	priority int
+	res      Result
+	// last is the progress of the job when it is not running
+	last FileProgress
+
+	// guarded by d.mu, stop ends the current run and tr counts its bytes, paused is set by
+	// Pause until the run stopped
+	stop   context.CancelFunc
+	tr     *transfer
+	paused bool
+}
+
+func newJobHandle(ctx context.Context, j Job) *JobHandle {
+	h := &JobHandle{
+		Job:      j,
+		done:     make(chan struct{}),
+		priority: j.Priority,
+		last:     FileProgress{URL: j.URL, Size: -1, ETA: -1},
+	}
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	return h
+}
+
+// Status returns where the job is at
+func (h *JobHandle) Status() JobStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// Done is closed once the job finished, failed or was canceled
+func (h *JobHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait returns the result of the job once it is over
+func (h *JobHandle) Wait() Result {
+	<-h.done
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.res
+}
+
+// Cancel stops the job, leaving the other jobs of the downloader be
+func (h *JobHandle) Cancel() {
+	h.cancel()
+	if h.d != nil {
+		h.d.release(h)
+	}
+}
+
+// Pause stops the job until Resume is called, keeping what was received to resume from when
+// the server allows it. Wait does not wait for paused jobs and Close cancels them.
+func (h *JobHandle) Pause() error {
+	d := h.d
+	if d == nil {
+		return ErrJobOver
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch h.Status() {
+	case JobQueued:
+		if d.closed {
+			return ErrClosed
+		}
+		// a job taken by a routine already stops once it runs
+		h.paused = true
+		if jb, ok := d.sched.remove(h); ok {
+			d.held[h] = jb
+			h.setStatus(JobPaused)
+		}
+	case JobRunning:
+		if d.closed {
+			return ErrClosed
+		}
+		h.paused = true
+		h.stop()
+	case JobPaused:
+	default:
+		return ErrJobOver
+	}
+	return nil
+}
+
+// Resume queues a paused job again
+func (h *JobHandle) Resume() error {
+	d := h.d
+	if d == nil {
+		return ErrJobOver
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch h.Status() {
+	case JobPaused:
+		if d.closed || d.ctx.Err() != nil {
+			return ErrClosed
+		}
+		jb := d.held[h]
+		delete(d.held, h)
+		h.paused = false
+		h.setStatus(JobQueued)
+		d.sched.requeue(jb)
+	case JobQueued:
+		h.paused = false
+	case JobRunning:
+	default:
+		return ErrJobOver
+	}
+	return nil
+}
+
+// Priority returns the priority of the job
+func (h *JobHandle) Priority() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.priority
+}
+
+// SetPriority changes the priority of the job, which orders it among the jobs waiting for a
+// routine when it is queued or paused
+func (h *JobHandle) SetPriority(p int) {
+	d := h.d
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h.mu.Lock()
+	h.priority = p
+	h.mu.Unlock()
+	if jb, ok := d.held[h]; ok {
+		jb.Priority = p
+		d.held[h] = jb
+		return
+	}
+	d.sched.reprioritize(h, p)
+}
+
+// Progress returns the progress of the job, the bytes received are known while it runs only
+// when the downloader reports progress with WithProgress
+func (h *JobHandle) Progress() FileProgress {
+	if d := h.d; d != nil {
+		d.mu.Lock()
+		tr := h.tr
+		d.mu.Unlock()
+		if tr != nil {
+			tr.t.mu.Lock()
+			defer tr.t.mu.Unlock()
+			return tr.progress()
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+func (h *JobHandle) setStatus(s JobStatus) {
+	h.mu.Lock()
+	h.status = s
+	h.mu.Unlock()
+}
+
+// finish records the result of the job, once
+func (h *JobHandle) finish(res Result, s JobStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.done:
+		return
+	default:
+	}
+	h.res, h.status = res, s
+	if res.Path != "" {
+		h.last.Name, h.last.Bytes = filepath.Base(res.Path), res.Bytes
+	}
+	h.last.Rate, h.last.ETA = 0, -1
+	if res.OK() {
+		h.last.Size, h.last.ETA = res.Bytes, 0
+	}
+	close(h.done)
+	h.cancel()
+}
+
+// Downloader downloads the jobs submitted to it into a directory with a pool of routines,
+// configured by the same Options as Fetch, until it is closed
+type Downloader struct {
+	cfg    *config
+	outdir string
+	b      *batch
+	sched  *scheduler
+	track  *tracker
+	jar    *cookieJar
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	workers   sync.WaitGroup
+	stopTrack chan struct{}
+	tracked   chan struct{}
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	closed   bool
+	finished bool
+	// held are the jobs paused
+	held    map[*JobHandle]job
+	started time.Time
+	results []Result
+	out     chan Result
+
+	closeOnce sync.Once
+	report    *Report
+	err       error
+}
+
+// NewDownloader returns a downloader saving into outdir, its routines run until it is closed
+// or ctx is done
+func NewDownloader(ctx context.Context, outdir string, opts ...Option) (*Downloader, error) {
+	cfg := newConfig(opts)
+	if _, err := os.ReadDir(outdir); err != nil {
+		return nil, err
+	}
+	d := &Downloader{cfg: cfg, outdir: outdir, started: time.Now(), held: make(map[*JobHandle]job)}
+	d.cond = sync.NewCond(&d.mu)
+	var jar http.CookieJar
+	if cfg.cookieFile != "" {
+		cj, err := loadCookies(cfg.cookieFile)
+		if err != nil {
+			return nil, fmt.Errorf("cookies %s load failed with %v", cfg.cookieFile, err)
+		}
+		jar, d.jar = cj, cj
+	}
+	cli, err := cfg.transport.client(jar)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := cfg.transport.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	var st *store
+	if cfg.store != "" {
+		if st, err = loadStore(cfg.store, cfg.storeLink); err != nil {
+			return nil, fmt.Errorf("store %s load failed with %v", cfg.store, err)
+		}
+	}
+	var m *mirror
+	if cfg.mirror {
+		if m, err = loadMirror(outdir); err != nil {
+			return nil, err
+		}
+	}
+	var crawl *crawler
+	if cfg.crawl != nil {
+		crawl = newCrawler(*cfg.crawl)
+	}
+	d.ctx, d.cancel = context.WithCancel(ctx)
+	d.sched = newScheduler(cfg.hostConns, cfg.hostDelay)
+	d.b = &batch{
+		cfg:    cfg,
+		cli:    cli,
+		tls:    tlsCfg,
+		outdir: outdir,
+		sem:    newSlots(cfg.routines),
+		limit:  newRateLimiter(cfg.rate, cfg.hostRate),
+		sched:  d.sched,
+		claims: claims{paths: make(map[string]int)},
+		mirror: m,
+		crawl:  crawl,
+		speeds: speeds{hosts: make(map[string]float64)},
+		store:  st,
+		add:    func(jb job) { d.add(jb) },
+	}
+	if d.track = newTracker(cfg.progress, cfg.progressEvery); d.track != nil {
+		d.stopTrack, d.tracked = make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(d.tracked)
+			d.track.run(d.stopTrack)
+		}()
+	}
+	for i := 0; i < cfg.routines; i++ {
+		d.workers.Add(1)
+		go func() {
+			defer d.workers.Done()
+			d.work()
+		}()
+	}
+	return d, nil
+}
+
+// Submit queues j and returns its handle, a job with an invalid url or an output leaving
+// outdir fails
+func (d *Downloader) Submit(j Job) *JobHandle {
+	err := checkURL(j.URL)
+	if err == nil && j.Output != "" {
+		j.Output, err = cleanOutput(j.Output)
+	}
+	return d.submit(job{Job: j, err: err})
+}
+
+// SubmitFrom submits the jobs read from r in the input format of the downloader, an entry
+// that can not be parsed fails with an *InputError. It returns once r is read.
+func (d *Downloader) SubmitFrom(r io.Reader) error {
+	return readJobs(d.ctx, r, d.cfg.input, func(j Job, err error) {
+		d.submit(job{Job: j, err: err})
+	})
+}
+
+func (d *Downloader) submit(jb job) *JobHandle {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || d.ctx.Err() != nil {
+		err := ErrClosed
+		if !d.closed {
+			err = d.ctx.Err()
+		}
+		h := newJobHandle(d.ctx, jb.Job)
+		h.finish(Result{URL: jb.URL, Err: err}, JobCanceled)
+		return h
+	}
+	if d.b.crawl != nil && jb.err == nil {
+		// urls of other schemes than http and https are downloaded but not crawled
+		if u, err := url.Parse(jb.URL); err == nil {
+			jb.root = normalize(u)
+		}
+		if jb.root != nil {
+			d.b.crawl.visit(jb.root.String())
+		}
+	}
+	return d.add(jb)
+}
+
+// add queues jb, the links found while crawling are added after the downloader is closed too
+func (d *Downloader) add(jb job) *JobHandle {
+	jb.h = newJobHandle(d.ctx, jb.Job)
+	jb.h.d = d
+	d.sched.add(jb)
+	return jb.h
+}
+
+// work downloads the jobs taken from the scheduler until it is closed and idle or the
+// downloader is stopped, jobs not taken before a stop are left out of the report
+func (d *Downloader) work() {
+	cfg, b := d.cfg, d.b
+	for {
+		jb, ok := d.sched.take(d.ctx)
+		if !ok {
+			return
+		}
+		h := jb.h
+		ctx, stop := context.WithCancel(h.ctx)
+		res := Result{URL: jb.URL, origin: jb.URL, host: hostOf(jb.URL), held: true, index: jb.index, crawled: jb.depth > 0, tr: d.track.start(jb)}
+		if jb.root != nil {
+			res.origin = jb.root.String()
+		}
+		d.mu.Lock()
+		h.stop, h.tr = stop, res.tr
+		if h.paused {
+			stop()
+		}
+		h.setStatus(JobRunning)
+		d.mu.Unlock()
+		start := time.Now()
+		err := jb.err
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = cfg.retry.do(ctx, jb.URL, func() error {
+				if err := b.sem.acquire(ctx); err != nil {
+					return err
+				}
+				defer b.sem.release()
+				return b.download(ctx, jb.Job, &res)
+			})
+		}
+		res.Duration = time.Since(start)
+		if res.held {
+			// the host contacted last, which may be a mirror's
+			d.sched.done(res.host)
+		}
+		if err == nil && b.crawl != nil {
+			// robots.txt rules are shared by the jobs, they are fetched with the context of the batch
+			err = b.crawl.follow(d.ctx, b, jb, res.Path)
+		}
+		d.mu.Lock()
+		h.stop, h.tr = nil, nil
+		if err != nil && h.paused && h.ctx.Err() == nil && !d.closed {
+			// the part file is resumed from once the job is resumed
+			d.track.drop(res.tr)
+			last := FileProgress{URL: jb.URL, Size: -1, ETA: -1}
+			if res.tr != nil {
+				res.tr.t.mu.Lock()
+				last = res.tr.progress()
+				res.tr.t.mu.Unlock()
+				last.Rate, last.ETA = 0, -1
+			}
+			h.mu.Lock()
+			h.last = last
+			h.mu.Unlock()
+			d.held[h] = jb
+			h.setStatus(JobPaused)
+			d.mu.Unlock()
+			stop()
+			d.sched.finish()
+			continue
+		}
+		d.mu.Unlock()
+		d.track.finish(res.tr, err)
+		status := JobDone
+		if err != nil {
+			res.Err = err
+			var de *DownloadError
+			if errors.As(err, &de) {
+				res.Attempts = de.Attempts
+			}
+			status = JobFailed
+			switch {
+			case ctx.Err() != nil && d.ctx.Err() == nil:
+				// canceled by its handle
+				status, res.canceled = JobCanceled, true
+			case d.ctx.Err() != nil:
+				status = JobCanceled
+			case !cfg.continueOnError && jb.depth == 0:
+				d.cancel()
+			}
+		}
+		d.mu.Lock()
+		d.results = append(d.results, res)
+		d.cond.Broadcast()
+		d.mu.Unlock()
+		stop()
+		h.finish(res, status)
+		d.sched.finish()
+	}
+}
+
+// Results returns a channel of the result of every job as it finishes, starting with the
+// jobs finished already, closed once the downloader is closed. It must be drained.
+func (d *Downloader) Results() <-chan Result {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.out == nil {
+		d.out = make(chan Result)
+		go func() {
+			defer close(d.out)
+			for i := 0; ; i++ {
+				d.mu.Lock()
+				for i >= len(d.results) && !d.finished {
+					d.cond.Wait()
+				}
+				if i >= len(d.results) {
+					d.mu.Unlock()
+					return
+				}
+				res := d.results[i]
+				d.mu.Unlock()
+				d.out <- res
+			}
+		}()
+	}
+	return d.out
+}
+
+// Wait blocks until every job submitted, and every link crawled from them, is over and
+// returns the report of all the jobs so far. It stops at the first failure unless
+// WithContinueOnError is given, in which case the error only tells how many failed. The
+// downloader takes further jobs after Wait unless it stopped.
+func (d *Downloader) Wait() (*Report, error) {
+	if err := d.sched.idle(d.ctx); err != nil {
+		d.workers.Wait()
+		d.drain()
+	}
+	rep, err := d.outcome()
+	if ferr := d.b.mirror.flush(); ferr != nil && err == nil {
+		err = fmt.Errorf("mirror index save failed with %v", ferr)
+	}
+	return rep, err
+}
+
+// Close stops taking jobs, waits for the ones submitted and returns the report as Wait does.
+// Links are converted and cookies saved when configured.
+func (d *Downloader) Close() (*Report, error) {
+	d.closeOnce.Do(func() {
+		d.mu.Lock()
+		d.closed = true
+		d.mu.Unlock()
+		d.sched.close()
+		d.workers.Wait()
+		d.drain()
+		if d.track != nil {
+			close(d.stopTrack)
+			<-d.tracked
+		}
+		d.report, d.err = d.outcome()
+		d.report.Finished = time.Now()
+		d.mu.Lock()
+		d.finished = true
+		d.cond.Broadcast()
+		d.mu.Unlock()
+		if err := d.b.mirror.flush(); err != nil && d.err == nil {
+			d.err = fmt.Errorf("mirror index save failed with %v", err)
+		}
+		if crawl := d.b.crawl; crawl != nil && crawl.ConvertLinks {
+			if err := crawl.convert(d.report); err != nil && d.err == nil {
+				d.err = fmt.Errorf("link conversion failed with %v", err)
+			}
+		}
+		if d.jar != nil {
+			if err := d.jar.save(d.cfg.cookieFile); err != nil && d.err == nil {
+				d.err = fmt.Errorf("cookies %s save failed with %v", d.cfg.cookieFile, err)
+			}
+		}
+		d.cancel()
+	})
+	return d.report, d.err
+}
+
+// release finishes the canceled job of h when it is queued or paused, a running job finishes
+// once its routine sees it canceled
+func (d *Downloader) release(h *JobHandle) {
+	d.mu.Lock()
+	jb, ok := d.held[h]
+	if ok {
+		delete(d.held, h)
+	} else {
+		jb, ok = d.sched.remove(h)
+	}
+	if !ok {
+		d.mu.Unlock()
+		return
+	}
+	res := Result{URL: jb.URL, index: jb.index, crawled: jb.depth > 0, Err: context.Canceled, canceled: true}
+	d.results = append(d.results, res)
+	d.cond.Broadcast()
+	d.mu.Unlock()
+	h.finish(res, JobCanceled)
+}
+
+// drain cancels the jobs left queued by a stop and the paused ones
+func (d *Downloader) drain() {
+	err := d.ctx.Err()
+	if err == nil {
+		err = ErrClosed
+	}
+	jobs := d.sched.drain()
+	d.mu.Lock()
+	for h, jb := range d.held {
+		jobs = append(jobs, jb)
+		delete(d.held, h)
+	}
+	d.mu.Unlock()
+	for _, jb := range jobs {
+		jb.h.finish(Result{URL: jb.URL, index: jb.index, Err: err}, JobCanceled)
+	}
+}
+
+// outcome returns the report of the jobs finished and the error of the first failure, or the
+// count of failures under WithContinueOnError
+func (d *Downloader) outcome() (*Report, error) {
+	d.mu.Lock()
+	rep := &Report{Started: d.started, Finished: time.Now(), Results: append([]Result(nil), d.results...)}
+	d.mu.Unlock()
+
+	var firstErr error
+	for _, res := range rep.Results {
+		// downloads cut short by the first failure report context.Canceled, prefer the failure,
+		// failures of the links found while crawling are only reported
+		if res.Err != nil && !res.crawled && !res.canceled && (firstErr == nil || errors.Is(firstErr, context.Canceled)) {
+			firstErr = res.Err
+		}
+	}
+	sort.Slice(rep.Results, func(i, j int) bool {
+		return rep.Results[i].index < rep.Results[j].index
+	})
+	if d.cfg.continueOnError {
+		if n := rep.Failed(); n > 0 {
+			return rep, fmt.Errorf("%d of %d downloads failed", n, len(rep.Results))
+		}
+		return rep, nil
+	}
+	return rep, firstErr
+}
@@ -0,0 +1,158 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownloader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" && r.Method == http.MethodGet {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			w.Write(payload[:10])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		serveFile(path.Base(r.URL.Path))(w, r)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	d, err := NewDownloader(context.Background(), dir, WithRoutines(2), WithRetry(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []Result
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for res := range d.Results() {
+			results = append(results, res)
+		}
+	}()
+
+	// canceling a job leaves the others be
+	slow := d.Submit(Job{URL: srv.URL + "/slow"})
+	for slow.Status() != JobRunning {
+		time.Sleep(time.Millisecond)
+	}
+	slow.Cancel()
+	if res := slow.Wait(); !errors.Is(res.Err, context.Canceled) || slow.Status() != JobCanceled {
+		t.Fatalf("got %v %+v", slow.Status(), res)
+	}
+	a := d.Submit(Job{URL: srv.URL + "/a.bin"})
+	if res := a.Wait(); res.Err != nil || a.Status() != JobDone || res.Path != filepath.Join(dir, "a.bin") {
+		t.Fatalf("got %v %+v", a.Status(), res)
+	}
+	rep, err := d.Wait()
+	if err != nil || len(rep.Results) != 2 {
+		t.Fatalf("got %v %+v", err, rep)
+	}
+
+	// more jobs after Wait, an invalid one stops the downloader
+	b := d.Submit(Job{URL: srv.URL + "/b.bin"})
+	bad := d.Submit(Job{URL: "no scheme"})
+	rep, err = d.Close()
+	if err == nil || bad.Status() != JobFailed || len(rep.Results) < 3 {
+		t.Fatalf("got %v %v %+v", err, bad.Status(), rep)
+	}
+	if st := b.Status(); st != JobDone && st != JobCanceled {
+		t.Errorf("b.bin is %v", st)
+	}
+	late := d.Submit(Job{URL: srv.URL + "/c.bin"})
+	if res := late.Wait(); !errors.Is(res.Err, ErrClosed) {
+		t.Errorf("submitted after close: %+v", res)
+	}
+	<-collected
+	if len(results) != len(rep.Results) {
+		t.Errorf("results channel gave %d of %d results", len(results), len(rep.Results))
+	}
+}
+
+func TestPause(t *testing.T) {
+	var mu sync.Mutex
+	var gets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			gets = append(gets, name+" "+r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		if name == "big.bin" && r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+			// half the file, then nothing until the download is paused
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			w.Write(payload[:len(payload)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		serveFile(name)(w, r)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	d, err := NewDownloader(context.Background(), dir, WithRetry(RetryPolicy{MaxAttempts: 1}), WithProgress(func(Progress) {}, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := d.Submit(Job{URL: srv.URL + "/big.bin"})
+	for big.Progress().Bytes < int64(len(payload)/2) {
+		time.Sleep(time.Millisecond)
+	}
+	low := d.Submit(Job{URL: srv.URL + "/low.bin"})
+	high := d.Submit(Job{URL: srv.URL + "/high.bin", Priority: 1})
+	mid := d.Submit(Job{URL: srv.URL + "/mid.bin"})
+	mid.SetPriority(2)
+	gone := d.Submit(Job{URL: srv.URL + "/gone.bin"})
+	if err := gone.Pause(); err != nil || gone.Status() != JobPaused {
+		t.Fatalf("got %v %v", err, gone.Status())
+	}
+	gone.Cancel()
+	if res := gone.Wait(); !errors.Is(res.Err, context.Canceled) || gone.Status() != JobCanceled {
+		t.Errorf("canceled while paused: %v %+v", gone.Status(), res)
+	}
+
+	// pausing the running job frees the routine for the queued ones, by priority
+	if err := big.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []*JobHandle{low, high, mid} {
+		if res := h.Wait(); res.Err != nil {
+			t.Fatal(res.Err)
+		}
+	}
+	if big.Status() != JobPaused || big.Progress().Bytes != int64(len(payload)/2) {
+		t.Fatalf("big is %v at %+v", big.Status(), big.Progress())
+	}
+	if err := big.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if res := big.Wait(); res.Err != nil || big.Status() != JobDone {
+		t.Fatalf("got %v %+v", big.Status(), res)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "big.bin")); !bytes.Equal(b, payload) {
+		t.Error("resumed file differs")
+	}
+	want := []string{"big.bin ", "mid.bin ", "high.bin ", "low.bin ", fmt.Sprintf("big.bin bytes=%d-", len(payload)/2)}
+	if fmt.Sprint(gets) != fmt.Sprint(want) {
+		t.Errorf("got requests %q, want %q", gets, want)
+	}
+	if err := big.Pause(); !errors.Is(err, ErrJobOver) {
+		t.Errorf("paused a finished job: %v", err)
+	}
+	if _, err := d.Close(); err != nil {
+		t.Error(err)
+	}
+}
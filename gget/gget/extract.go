@@ -0,0 +1,372 @@
+package gget
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveKind is an archive or compressed file extracted by its name suffix
+type archiveKind struct {
+	suffix string
+	tar    bool
+	codec  string
+}
+
+// errXZUnsupported fails the extraction of xz files, the standard library has no xz reader
+var errXZUnsupported = errors.New("xz is not supported, the file is kept as downloaded")
+
+// archiveKinds are the kinds extracted, longest suffix first, xz ones only to fail clearly
+var archiveKinds = []archiveKind{
+	{".tar.gz", true, "gzip"},
+	{".tar.bz2", true, "bzip2"},
+	{".tar.xz", true, "xz"},
+	{".tgz", true, "gzip"},
+	{".tbz2", true, "bzip2"},
+	{".tbz", true, "bzip2"},
+	{".txz", true, "xz"},
+	{".tar", true, ""},
+	{".zip", false, "zip"},
+	{".gz", false, "gzip"},
+	{".bz2", false, "bzip2"},
+	{".xz", false, "xz"},
+}
+
+// archiveOf returns the kind of the archive at path and the path it extracts to, its path
+// without the suffix, nil when it is no archive
+func archiveOf(path string) (*archiveKind, string) {
+	lower := strings.ToLower(path)
+	for i, k := range archiveKinds {
+		if strings.HasSuffix(lower, k.suffix) && len(filepath.Base(path)) > len(k.suffix) {
+			return &archiveKinds[i], path[:len(path)-len(k.suffix)]
+		}
+	}
+	return nil, ""
+}
+
+// extractedPrefix starts the name of the file next to what gget extracted marking it as such
+const extractedPrefix = ".gget-extracted-"
+
+// extracted reports whether gget extracted the file or directory at dest
+func extracted(dest string) bool {
+	_, err := os.Lstat(filepath.Join(filepath.Dir(dest), extractedPrefix+filepath.Base(dest)))
+	return err == nil
+}
+
+// extract unpacks the archive at path of kind k into the directory dest, or decompresses a
+// single compressed file to dest, replacing what is there and marking dest as extracted
+func extract(ctx context.Context, path string, k *archiveKind, dest string) error {
+	var err error
+	switch {
+	case k.codec == "zip":
+		err = extractDir(dest, func(dir string) error { return extractZip(path, dir) })
+	case k.tar:
+		err = extractDir(dest, func(dir string) error { return extractTar(ctx, path, k.codec, dir) })
+	default:
+		err = decompressFile(path, k.codec, dest)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(filepath.Dir(dest), extractedPrefix+filepath.Base(dest)), nil, 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("extract %s failed with %w", path, err)
+	}
+	return nil
+}
+
+// extractDir extracts into a temporary directory next to dest with fill and renames it to
+// dest once complete, so a failed extraction leaves nothing behind
+func extractDir(dest string, fill func(dir string) error) error {
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".extract-")
+	if err != nil {
+		return err
+	}
+	if err := fill(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// decompress returns a reader of r decompressed with codec
+func decompress(r io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case "":
+		return io.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case "xz":
+		return nil, errXZUnsupported
+	}
+	return nil, fmt.Errorf("unknown codec %q", codec)
+}
+
+// decompressFile decompresses the file at path to dest, keeping its mode and time
+func decompressFile(path, codec, dest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := decompress(f, codec)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".extract-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), fi.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(out.Name(), fi.ModTime(), fi.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+// unpacker creates the entries of an archive within dir, refusing entries that would end up
+// outside of it, and sets the modes and times of directories once all entries are written
+type unpacker struct {
+	dir   string
+	dirs  []dirEntry
+	links []string
+}
+
+type dirEntry struct {
+	path  string
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+// path returns where the entry name goes within dir. Absolute names and names climbing out
+// with .. are refused, as are names below a symlink an earlier entry created.
+func (u *unpacker) path(name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry %q is outside of the archive directory", name)
+	}
+	if rel == "." {
+		return u.dir, nil
+	}
+	p := filepath.Join(u.dir, rel)
+	for parent := filepath.Dir(p); parent != u.dir; parent = filepath.Dir(parent) {
+		if fi, err := os.Lstat(parent); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("entry %q is below a symlink", name)
+		}
+	}
+	return p, nil
+}
+
+func (u *unpacker) mkdir(p string, mode fs.FileMode, mtime time.Time) error {
+	if err := os.MkdirAll(p, 0o755); err != nil {
+		return err
+	}
+	u.dirs = append(u.dirs, dirEntry{p, mode.Perm(), mtime})
+	return nil
+}
+
+func (u *unpacker) file(p string, r io.Reader, mode fs.FileMode, mtime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// a later entry of the same name replaces the earlier one rather than writing through it
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(p, mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(p, mtime, mtime)
+}
+
+// symlink creates a symlink at p to target, which must stay within dir
+func (u *unpacker) symlink(p, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("symlink %s to absolute %s", p, target)
+	}
+	if rel, err := filepath.Rel(u.dir, filepath.Join(filepath.Dir(p), target)); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("symlink %s to %s is outside of the archive directory", p, target)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	u.links = append(u.links, p)
+	return os.Symlink(target, p)
+}
+
+// finish checks that the symlinks resolve within dir, through one another too, and sets the
+// modes and times of the directories, deepest first
+func (u *unpacker) finish() error {
+	root, err := filepath.EvalSymlinks(u.dir)
+	if err != nil {
+		return err
+	}
+	for _, l := range u.links {
+		resolved, err := filepath.EvalSymlinks(l)
+		if err != nil {
+			// dangling, its target was checked when it was created
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("symlink %s resolves outside of the archive directory", l)
+		}
+	}
+	for i := len(u.dirs) - 1; i >= 0; i-- {
+		d := u.dirs[i]
+		if err := os.Chmod(d.path, d.mode|0o700); err != nil {
+			return err
+		}
+		if err := os.Chtimes(d.path, d.mtime, d.mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar unpacks the tar file at path, compressed with codec, into dir
+func extractTar(ctx context.Context, path, codec, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompress(f, codec)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	u := &unpacker{dir: dir}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p, err := u.path(hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := fs.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = u.mkdir(p, mode, hdr.ModTime)
+		case tar.TypeReg:
+			err = u.file(p, tr, mode, hdr.ModTime)
+		case tar.TypeSymlink:
+			err = u.symlink(p, hdr.Linkname)
+		case tar.TypeLink:
+			var src string
+			if src, err = u.path(hdr.Linkname); err == nil {
+				os.Remove(p)
+				err = os.Link(src, p)
+			}
+		default:
+			// devices, fifos and the like are not extracted
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return u.finish()
+}
+
+// extractZip unpacks the zip file at path into dir
+func extractZip(path, dir string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	u := &unpacker{dir: dir}
+	for _, zf := range zr.File {
+		p, err := u.path(zf.Name)
+		if err != nil {
+			return err
+		}
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = u.mkdir(p, mode, zf.Modified)
+		case mode&fs.ModeSymlink != 0:
+			var target []byte
+			if target, err = readZipFile(zf); err == nil {
+				err = u.symlink(p, string(target))
+			}
+		default:
+			var rc io.ReadCloser
+			if rc, err = zf.Open(); err == nil {
+				err = u.file(p, rc, mode, zf.Modified)
+				rc.Close()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return u.finish()
+}
+
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,281 @@
+package gget
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtract(t *testing.T) {
+	type entry struct {
+		name, body, link string
+		mode             int64
+		dir              bool
+	}
+	writeTar := func(t *testing.T, w io.Writer, entries []entry) {
+		tw := tar.NewWriter(w)
+		for _, e := range entries {
+			hdr := &tar.Header{Name: e.name, Mode: e.mode, Size: int64(len(e.body)), ModTime: time.Unix(1700000000, 0), Typeflag: tar.TypeReg}
+			switch {
+			case e.dir:
+				hdr.Typeflag = tar.TypeDir
+			case e.link != "":
+				hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, e.link
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(tw, e.body)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tarGz := func(t *testing.T, path string, entries []entry) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		writeTar(t, zw, entries)
+		zw.Close()
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zipFile := func(t *testing.T, path string, entries []entry) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, e := range entries {
+			fh := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+			fh.SetMode(fs.FileMode(e.mode))
+			w, err := zw.CreateHeader(fh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, e.body)
+		}
+		zw.Close()
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetch := func(t *testing.T, src []string, opts ...Option) (*Report, string) {
+		t.Helper()
+		dir := t.TempDir()
+		var in strings.Builder
+		for _, s := range src {
+			if !strings.Contains(s, "://") {
+				s = "file://" + filepath.ToSlash(s)
+			}
+			in.WriteString(s + "\n")
+		}
+		opts = append(opts, WithLocalFiles(), WithRetry(RetryPolicy{MaxAttempts: 1}), WithContinueOnError())
+		rep, _ := Fetch(context.Background(), strings.NewReader(in.String()), 1, dir, opts...)
+		return rep, dir
+	}
+	check := func(t *testing.T, path, want string, mode fs.FileMode) {
+		t.Helper()
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, wanted %q", path, got, want)
+		}
+		if fi, err := os.Stat(path); err == nil && mode != 0 && fi.Mode().Perm() != mode {
+			t.Errorf("%s: got mode %v, wanted %v", path, fi.Mode().Perm(), mode)
+		}
+	}
+	src := t.TempDir()
+
+	t.Run("test archives", func(t *testing.T) {
+		entries := []entry{
+			{name: "pkg/", dir: true, mode: 0o755},
+			{name: "pkg/bin/run", body: "#!/bin/sh\n", mode: 0o755},
+			{name: "pkg/README", body: "read me", mode: 0o644},
+			{name: "pkg/latest", link: "README"},
+		}
+		tarGz(t, filepath.Join(src, "pkg.tar.gz"), entries)
+		zipFile(t, filepath.Join(src, "pkg.zip"), entries[1:3])
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		io.WriteString(zw, "just compressed")
+		zw.Close()
+		if err := os.WriteFile(filepath.Join(src, "notes.txt.gz"), gz.Bytes(), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		rep, dir := fetch(t, []string{filepath.Join(src, "pkg.tar.gz"), filepath.Join(src, "pkg.zip"), filepath.Join(src, "notes.txt.gz")}, WithExtract(false))
+		if rep.Failed() != 0 {
+			t.Fatalf("got %+v", rep.Results)
+		}
+		check(t, filepath.Join(dir, "pkg", "pkg", "bin", "run"), "#!/bin/sh\n", 0o755)
+		check(t, filepath.Join(dir, "pkg", "pkg", "latest"), "read me", 0)
+		// the zip extracts next to the tar rather than over it
+		check(t, filepath.Join(dir, "pkg-1", "pkg", "README"), "read me", 0o644)
+		check(t, filepath.Join(dir, "notes.txt"), "just compressed", 0o644)
+		if rep.Results[0].Extracted != filepath.Join(dir, "pkg") {
+			t.Errorf("extracted to %s", rep.Results[0].Extracted)
+		}
+		if _, err := os.Stat(rep.Results[0].Path); err != nil {
+			t.Error("archive removed without asking")
+		}
+
+		// the archive is removed if asked to
+		rep, _ = fetch(t, []string{filepath.Join(src, "pkg.tar.gz")}, WithExtract(true))
+		if _, err := os.Stat(rep.Results[0].Path); !errors.Is(err, fs.ErrNotExist) {
+			t.Error("archive kept")
+		}
+	})
+
+	t.Run("test conflicts", func(t *testing.T) {
+		tarGz(t, filepath.Join(src, "app.tar.gz"), []entry{{name: "new", body: "new", mode: 0o644}})
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		io.WriteString(zw, "new")
+		zw.Close()
+		if err := os.WriteFile(filepath.Join(src, "log.txt.gz"), gz.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		in := "file://" + filepath.ToSlash(filepath.Join(src, "app.tar.gz")) + "\nfile://" + filepath.ToSlash(filepath.Join(src, "log.txt.gz")) + "\n"
+		fetchInto := func(t *testing.T, dir string, policy Conflict) *Report {
+			t.Helper()
+			rep, _ := Fetch(context.Background(), strings.NewReader(in), 1, dir, WithLocalFiles(), WithExtract(true),
+				WithConflict(policy), WithRetry(RetryPolicy{MaxAttempts: 1}), WithContinueOnError())
+			return rep
+		}
+		mine := func(t *testing.T) string {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "app"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range []string{filepath.Join(dir, "app", "own"), filepath.Join(dir, "log.txt")} {
+				if err := os.WriteFile(p, []byte("mine"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return dir
+		}
+
+		// a directory gget did not extract is never replaced, an earlier extraction is, the
+		// archives are removed so only the extraction conflicts the second time
+		dir := mine(t)
+		rep := fetchInto(t, dir, ConflictOverwrite)
+		check(t, filepath.Join(dir, "app", "own"), "mine", 0)
+		check(t, filepath.Join(dir, "app-1", "new"), "new", 0)
+		check(t, filepath.Join(dir, "log.txt"), "new", 0)
+		if rep.Failed() != 0 {
+			t.Fatalf("got %+v", rep.Results)
+		}
+		rep = fetchInto(t, dir, ConflictOverwrite)
+		if _, err := os.Stat(filepath.Join(dir, "app-2")); err == nil || rep.Failed() != 0 || rep.Results[0].Extracted != filepath.Join(dir, "app-1") {
+			t.Fatalf("expected the earlier extraction to be replaced but got %+v", rep.Results)
+		}
+
+		dir = mine(t)
+		rep = fetchInto(t, dir, ConflictSkip)
+		check(t, filepath.Join(dir, "log.txt"), "mine", 0)
+		if _, err := os.Stat(filepath.Join(dir, "app-1")); err == nil || rep.Failed() != 0 || rep.Results[0].Extracted != "" {
+			t.Errorf("expected the extraction to be skipped but got %+v", rep.Results)
+		}
+
+		dir = mine(t)
+		rep = fetchInto(t, dir, ConflictError)
+		check(t, filepath.Join(dir, "log.txt"), "mine", 0)
+		for _, res := range rep.Results {
+			if !errors.Is(res.Err, ErrExists) {
+				t.Errorf("expected ErrExists but got %v", res.Err)
+			}
+		}
+
+		dir = mine(t)
+		fetchInto(t, dir, ConflictRename)
+		check(t, filepath.Join(dir, "log.txt"), "mine", 0)
+		check(t, filepath.Join(dir, "log-1.txt"), "new", 0)
+	})
+
+	t.Run("test traversal", func(t *testing.T) {
+		for name, entries := range map[string][]entry{
+			"dotdot.tar.gz":  {{name: "ok", body: "x", mode: 0o644}, {name: "../evil", body: "x", mode: 0o644}},
+			"abs.tar.gz":     {{name: "/tmp/evil", body: "x", mode: 0o644}},
+			"link.tar.gz":    {{name: "l", link: "../../etc"}},
+			"through.tar.gz": {{name: "d", link: "."}, {name: "d/l", link: ".."}},
+			"dotdot-zip.zip": {{name: "../../evil", body: "x", mode: 0o644}},
+		} {
+			path := filepath.Join(src, name)
+			if strings.HasSuffix(name, ".zip") {
+				zipFile(t, path, entries)
+			} else {
+				tarGz(t, path, entries)
+			}
+			rep, dir := fetch(t, []string{path}, WithExtract(false))
+			if rep.Failed() != 1 {
+				t.Errorf("%s: extracted %+v", name, rep.Results)
+			}
+			left, _ := os.ReadDir(dir)
+			if len(left) != 1 {
+				t.Errorf("%s: left %v", name, left)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "evil")); err == nil {
+				t.Errorf("%s: wrote outside", name)
+			}
+		}
+	})
+
+	t.Run("test xz", func(t *testing.T) {
+		for _, name := range []string{"a.tar.xz", "b.txz", "c.txt.xz"} {
+			if err := os.WriteFile(filepath.Join(src, name), []byte("\xfd7zXZ\x00"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rep, dir := fetch(t, []string{filepath.Join(src, "a.tar.xz"), filepath.Join(src, "b.txz"), filepath.Join(src, "c.txt.xz")}, WithExtract(true))
+		for _, res := range rep.Results {
+			if !errors.Is(res.Err, errXZUnsupported) {
+				t.Errorf("%s: expected xz to be unsupported but got %v", res.URL, res.Err)
+			}
+			if _, err := os.Stat(res.Path); err != nil {
+				t.Errorf("%s: expected the file to be kept: %v", res.URL, err)
+			}
+		}
+		if left, _ := os.ReadDir(dir); len(left) != 3 {
+			t.Errorf("expected only the downloads to be left but got %v", left)
+		}
+	})
+
+	t.Run("test gzip content encoding", func(t *testing.T) {
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		zw.Write(payload)
+		zw.Close()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, ".gz") {
+				w.Header().Set("Content-Type", "application/gzip")
+			} else {
+				w.Header().Set("Content-Type", "application/octet-stream")
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gz.Bytes())
+		}))
+		defer srv.Close()
+		// asking for the encoding keeps the transport from decoding it
+		h := WithHeader(http.Header{"Accept-Encoding": {"gzip"}})
+		_, dir := fetch(t, []string{srv.URL + "/data.bin", srv.URL + "/data.bin.gz"}, h)
+		got, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("encoded body not decoded: %v", err)
+		}
+		got, err = os.ReadFile(filepath.Join(dir, "data.bin.gz"))
+		if err != nil || !bytes.Equal(got, gz.Bytes()) {
+			t.Fatalf("gzip file decoded: %v", err)
+		}
+	})
+}
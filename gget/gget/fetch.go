@@ -0,0 +1,219 @@
+package gget
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fetcher fetches the urls of the schemes it is registered for, in place of the http client
+type Fetcher interface {
+	// Fetch opens the content of u from offset on, a fetcher that can not skip to offset
+	// returns it from the start
+	Fetch(ctx context.Context, u *url.URL, offset int64) (*Resource, error)
+}
+
+// Resource is the content a Fetcher opened
+type Resource struct {
+	Body io.ReadCloser
+	// Offset is where Body starts within the content
+	Offset int64
+	// Size is the size of the whole content, -1 when unknown
+	Size int64
+	// Name is the file name suggested for the content, if any
+	Name string
+	// Type is the media type of the content, if known
+	Type string
+	// ModTime is when the content changed last, if known, an interrupted download is only
+	// resumed when it is
+	ModTime time.Time
+}
+
+// header returns the response headers describing r, used to name its file
+func (r *Resource) header() http.Header {
+	h := http.Header{}
+	if r.Name != "" {
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": r.Name}))
+	}
+	if r.Type != "" {
+		h.Set("Content-Type", r.Type)
+	}
+	if !r.ModTime.IsZero() {
+		h.Set("Last-Modified", r.ModTime.UTC().Format(http.TimeFormat))
+	}
+	return h
+}
+
+var fetchers = struct {
+	sync.RWMutex
+	m map[string]Fetcher
+}{m: map[string]Fetcher{
+	"data": dataFetcher{},
+	"ftp":  &FTP{},
+	"ftps": &FTP{},
+}}
+
+// RegisterFetcher makes f fetch the urls of scheme, replacing the fetcher registered for it
+// before. http and https urls use the http client unless a fetcher is registered for them, file
+// urls are only fetched with WithLocalFiles unless a fetcher is registered for them.
+func RegisterFetcher(scheme string, f Fetcher) {
+	if f == nil {
+		panic("gget: RegisterFetcher of a nil fetcher")
+	}
+	fetchers.Lock()
+	defer fetchers.Unlock()
+	fetchers.m[strings.ToLower(scheme)] = f
+}
+
+// fetcherFor returns the fetcher registered for the scheme of link, nil for the http client
+func (b *batch) fetcherFor(link string) (Fetcher, *url.URL) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, nil
+	}
+	scheme := strings.ToLower(u.Scheme)
+	fetchers.RLock()
+	f := fetchers.m[scheme]
+	fetchers.RUnlock()
+	if f == nil && scheme == "file" {
+		f = fileFetcher{allowed: b.cfg.localFiles}
+	}
+	if ftp, ok := f.(*FTP); ok && ftp.TLS == nil {
+		// the built in ftp fetcher uses the TLS settings and connect timeout of the batch
+		c := *ftp
+		c.TLS = b.tls
+		if c.Timeout == 0 {
+			c.Timeout = b.cfg.transport.ConnectTimeout
+		}
+		f = &c
+	}
+	return f, u
+}
+
+// downloadFetched fetches j with f, resuming the part file of an earlier run when the content
+// did not change since
+func (b *batch) downloadFetched(ctx context.Context, j Job, f Fetcher, u *url.URL, res *Result) error {
+	link := j.URL
+	if u.User == nil && u.Host != "" {
+		switch {
+		case b.cfg.user != "" && strings.EqualFold(u.Host, hostOf(res.origin)):
+			// the login is for the host of the url as given, not for its mirrors elsewhere
+			u.User = url.UserPassword(b.cfg.user, b.cfg.password)
+		default:
+			if l, ok := b.cfg.netrc.login(u.Hostname()); ok && l.login != "" {
+				u.User = url.UserPassword(l.login, l.password)
+			}
+		}
+	}
+	r, err := f.Fetch(ctx, u, 0)
+	if err != nil {
+		return fmt.Errorf("fetch %s failed with %w", link, err)
+	}
+	defer func() { r.Body.Close() }()
+	header := r.header()
+	path, skip, err := b.target(j, header, res)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return skipped(path, res)
+	}
+	part := path + partExt
+	st := &partState{URL: link, LastModified: header.Get("Last-Modified"), Size: r.Size}
+	var offset int64
+	if ps, err := loadPartState(part); err == nil && ps.Segments == nil && ps.Received > 0 &&
+		ps.URL == link && st.LastModified != "" && ps.LastModified == st.LastModified && ps.Size == st.Size {
+		if fi, err := os.Stat(part); err == nil && fi.Size() >= ps.Received {
+			rr, err := f.Fetch(ctx, u, ps.Received)
+			if err != nil {
+				return fmt.Errorf("fetch %s failed with %w", link, err)
+			}
+			r.Body.Close()
+			r, offset = rr, rr.Offset
+		}
+	}
+	return b.receive(ctx, j, path, st, offset, r.Body, res)
+}
+
+// errLocalFiles fails file urls fetched without WithLocalFiles
+var errLocalFiles = errors.New("file urls are not allowed without local files on")
+
+// fileFetcher copies local files of file urls, if allowed
+type fileFetcher struct {
+	allowed bool
+}
+
+func (ff fileFetcher) Fetch(ctx context.Context, u *url.URL, offset int64) (*Resource, error) {
+	if !ff.allowed {
+		return nil, errLocalFiles
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("file url of remote host %q", u.Host)
+	}
+	f, err := os.Open(filepath.FromSlash(u.Path))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory", u.Path)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Resource{Body: f, Offset: offset, Size: fi.Size(), Name: fi.Name(), ModTime: fi.ModTime()}, nil
+}
+
+// dataFetcher decodes RFC 2397 data urls
+type dataFetcher struct{}
+
+func (dataFetcher) Fetch(ctx context.Context, u *url.URL, offset int64) (*Resource, error) {
+	raw := u.Opaque
+	if u.RawQuery != "" {
+		raw += "?" + u.RawQuery
+	}
+	params, encoded, ok := strings.Cut(raw, ",")
+	if !ok {
+		return nil, errors.New("data url without a comma")
+	}
+	data, err := url.PathUnescape(encoded)
+	if err != nil {
+		return nil, err
+	}
+	mediaType := strings.TrimSuffix(params, ";base64")
+	if mediaType != params {
+		b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "="))
+		if err != nil {
+			return nil, fmt.Errorf("data url base64 decode failed with %v", err)
+		}
+		data = string(b)
+	}
+	if mediaType == "" || strings.HasPrefix(mediaType, ";") {
+		mediaType = "text/plain" + mediaType
+	}
+	if offset > int64(len(data)) {
+		offset = 0
+	}
+	return &Resource{
+		Body:   io.NopCloser(strings.NewReader(data[offset:])),
+		Offset: offset,
+		Size:   int64(len(data)),
+		Type:   mediaType,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchers(t *testing.T) {
+	fetch := func(t *testing.T, in string, opts ...Option) (*Report, string) {
+		t.Helper()
+		dir := t.TempDir()
+		opts = append(opts, WithRetry(RetryPolicy{MaxAttempts: 1}), WithContinueOnError())
+		rep, _ := Fetch(context.Background(), strings.NewReader(in), 1, dir, opts...)
+		return rep, dir
+	}
+	check := func(t *testing.T, path string, want []byte) {
+		t.Helper()
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: got %d bytes, wanted %d", path, len(got), len(want))
+		}
+	}
+
+	t.Run("test file and data urls", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "staged.bin")
+		if err := os.WriteFile(src, payload, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		in := "file://" + filepath.ToSlash(src) + "\n" +
+			"data:text/plain;base64,aGVsbG8gd29ybGQ=\n" +
+			"data:,a%20b\n" +
+			"file:///does/not/exist\n"
+		rep, _ := fetch(t, in)
+		if !errors.Is(rep.Results[0].Err, errLocalFiles) {
+			t.Fatalf("expected file urls to be refused by default, got %v", rep.Results[0].Err)
+		}
+		rep, dir := fetch(t, in, WithLocalFiles())
+		check(t, filepath.Join(dir, "staged.bin"), payload)
+		check(t, rep.Results[1].Path, []byte("hello world"))
+		check(t, rep.Results[2].Path, []byte("a b"))
+		if rep.Failed() != 1 || rep.Results[3].Err == nil {
+			t.Fatalf("expected the missing file to fail, got %+v", rep.Results)
+		}
+	})
+
+	t.Run("test ftp resume and login", func(t *testing.T) {
+		srv := newFTPServer(t, map[string][]byte{"pub/f.bin": payload}, nil)
+		rep, dir := fetch(t, srv.url("ftp", "pub/f.bin")+"\n"+srv.url("ftp", "pub/missing")+"\n")
+		check(t, filepath.Join(dir, "f.bin"), payload)
+		if rep.Results[0].Bytes != int64(len(payload)) || rep.Results[1].Err == nil {
+			t.Fatalf("got %+v", rep.Results)
+		}
+
+		// a part file of an earlier run is resumed with REST
+		dir = t.TempDir()
+		part := filepath.Join(dir, "f.bin"+partExt)
+		if err := os.WriteFile(part, payload[:1000], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		st := &partState{URL: srv.url("ftp", "pub/f.bin"), LastModified: srv.mod.Format(http.TimeFormat), Size: int64(len(payload)), Received: 1000}
+		if err := st.save(part); err != nil {
+			t.Fatal(err)
+		}
+		n, err := ParseNetrc(strings.NewReader("machine 127.0.0.1 login bob password secret\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Get(context.Background(), strings.NewReader(srv.url("ftp", "pub/f.bin")), 1, dir, WithNetrc(n)); err != nil {
+			t.Fatal(err)
+		}
+		check(t, filepath.Join(dir, "f.bin"), payload)
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		if len(srv.rests) != 1 || srv.rests[0] != 1000 {
+			t.Errorf("got REST %v", srv.rests)
+		}
+		if last := srv.logins[len(srv.logins)-1]; last != "bob:secret" {
+			t.Errorf("logged in as %s", last)
+		}
+		if srv.logins[0] != "anonymous:anonymous@" {
+			t.Errorf("logged in as %s", srv.logins[0])
+		}
+	})
+
+	t.Run("test mirrors get neither logins nor local files", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "f.bin")
+		if err := os.WriteFile(src, []byte("local"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		srv := newFTPServer(t, map[string][]byte{"pub/f.bin": payload}, nil)
+		mirror := strings.Replace(srv.url("ftp", "pub/f.bin"), "127.0.0.1", "localhost", 1)
+		in := srv.url("ftp", "pub/missing") + " file://" + filepath.ToSlash(src) + " " + mirror + "\n"
+		rep, dir := fetch(t, in, WithBasicAuth("bob", "secret"), WithLocalFiles())
+		if rep.Failed() != 0 || rep.Results[0].Source != mirror {
+			t.Fatalf("expected the download from the ftp mirror, got %+v", rep.Results)
+		}
+		check(t, filepath.Join(dir, "f.bin"), payload)
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		if want := []string{"bob:secret", "anonymous:anonymous@"}; fmt.Sprint(srv.logins) != fmt.Sprint(want) {
+			t.Errorf("got logins %v, want %v", srv.logins, want)
+		}
+	})
+
+	t.Run("test ftps", func(t *testing.T) {
+		tlsSrv := httptest.NewTLSServer(http.NotFoundHandler())
+		defer tlsSrv.Close()
+		srv := newFTPServer(t, map[string][]byte{"f.bin": payload}, tlsSrv.TLS)
+		rep, _ := fetch(t, srv.url("ftps", "f.bin")+"\n")
+		if rep.Failed() != 1 {
+			t.Fatal("expected an unknown authority failure")
+		}
+		_, dir := fetch(t, srv.url("ftps", "f.bin")+"\n", WithTransport(TransportConfig{InsecureSkipVerify: true}))
+		check(t, filepath.Join(dir, "f.bin"), payload)
+	})
+
+	t.Run("test registered scheme", func(t *testing.T) {
+		RegisterFetcher("mem", memFetcher{"x": []byte("from memory")})
+		rep, _ := fetch(t, "mem://x/y.txt\n")
+		check(t, rep.Results[0].Path, []byte("from memory"))
+	})
+}
+
+// memFetcher serves the content of its hosts
+type memFetcher map[string][]byte
+
+func (m memFetcher) Fetch(ctx context.Context, u *url.URL, offset int64) (*Resource, error) {
+	data, ok := m[u.Host]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &Resource{Body: io.NopCloser(bytes.NewReader(data)), Size: int64(len(data)), Name: path.Base(u.Path)}, nil
+}
@@ -0,0 +1,271 @@
+package gget
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FTP fetches ftp and ftps urls over passive mode data connections, logging in as the user of
+// the url or anonymously. ftps urls secure the control and data connections with TLS.
+type FTP struct {
+	// TLS configures ftps connections, the batch's TransportConfig is used when nil
+	TLS *tls.Config
+	// ImplicitTLS starts ftps connections with TLS on port 990 by default, instead of
+	// upgrading them with AUTH TLS on port 21
+	ImplicitTLS bool
+	// Timeout limits connecting and each reply of the server, 0 for no limit
+	Timeout time.Duration
+}
+
+// ftpConn is the control connection of an ftp session
+type ftpConn struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	timeout time.Duration
+}
+
+// cmd sends the command and reads its reply, which must have the expect class or code
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	if format != "" {
+		if err := c.text.PrintfLine(format, args...); err != nil {
+			return 0, "", err
+		}
+	}
+	return c.text.ReadResponse(expect)
+}
+
+// secure wraps the connection in TLS
+func (c *ftpConn) secure(cfg *tls.Config) {
+	c.conn = tls.Client(c.conn, cfg)
+	c.text = textproto.NewConn(c.conn)
+}
+
+func (c *ftpConn) close() {
+	c.text.Close()
+}
+
+func (f *FTP) Fetch(ctx context.Context, u *url.URL, offset int64) (*Resource, error) {
+	file := strings.TrimPrefix(u.Path, "/")
+	if file == "" || strings.HasSuffix(file, "/") {
+		return nil, fmt.Errorf("ftp url %s names no file", u.Redacted())
+	}
+	secure := u.Scheme == "ftps"
+	addr := u.Host
+	if u.Port() == "" {
+		port := "21"
+		if secure && f.ImplicitTLS {
+			port = "990"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	var cfg *tls.Config
+	if secure {
+		if f.TLS != nil {
+			cfg = f.TLS.Clone()
+		} else {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		// data connections resume the session of the control connection, as servers demand
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	}
+
+	d := net.Dialer{Timeout: f.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{conn: conn, text: textproto.NewConn(conn), timeout: f.Timeout}
+	if secure && f.ImplicitTLS {
+		c.secure(cfg)
+	}
+	// closing the connections is the only way to interrupt them
+	var (
+		mu    sync.Mutex
+		conns = []net.Conn{conn}
+		stop  = make(chan struct{})
+	)
+	go func() {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			for _, c := range conns {
+				c.Close()
+			}
+			mu.Unlock()
+		case <-stop:
+		}
+	}()
+	fail := func(err error) (*Resource, error) {
+		close(stop)
+		c.close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	if _, _, err := c.cmd(2, ""); err != nil {
+		return fail(fmt.Errorf("ftp greeting failed with %w", err))
+	}
+	if secure && !f.ImplicitTLS {
+		if _, _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return fail(fmt.Errorf("ftp AUTH TLS failed with %w", err))
+		}
+		c.secure(cfg)
+	}
+	user, password := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if strings.ContainsAny(file+user+password, "\r\n") {
+		return fail(fmt.Errorf("ftp url %s has a line break", u.Redacted()))
+	}
+	code, _, err := c.cmd(0, "USER %s", user)
+	if code == 331 {
+		code, _, err = c.cmd(2, "PASS %s", password)
+	}
+	if code != 230 && code != 202 {
+		if err == nil {
+			err = fmt.Errorf("unexpected reply %d", code)
+		}
+		return fail(fmt.Errorf("ftp login as %s failed with %w", user, err))
+	}
+	if secure {
+		if _, _, err := c.cmd(2, "PBSZ 0"); err != nil {
+			return fail(fmt.Errorf("ftp PBSZ failed with %w", err))
+		}
+		if _, _, err := c.cmd(2, "PROT P"); err != nil {
+			return fail(fmt.Errorf("ftp PROT failed with %w", err))
+		}
+	}
+	if _, _, err := c.cmd(2, "TYPE I"); err != nil {
+		return fail(fmt.Errorf("ftp TYPE failed with %w", err))
+	}
+
+	r := &Resource{Size: -1, Name: path.Base(file)}
+	if _, msg, err := c.cmd(213, "SIZE %s", file); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err == nil {
+			r.Size = n
+		}
+	}
+	if _, msg, err := c.cmd(213, "MDTM %s", file); err == nil && len(msg) >= 14 {
+		if t, err := time.Parse("20060102150405", msg[:14]); err == nil {
+			r.ModTime = t
+		}
+	}
+	dataAddr, err := c.passive(u.Hostname())
+	if err != nil {
+		return fail(err)
+	}
+	if offset > 0 {
+		if _, _, err := c.cmd(350, "REST %d", offset); err == nil {
+			r.Offset = offset
+		}
+	}
+	data, err := d.DialContext(ctx, "tcp", dataAddr)
+	if err != nil {
+		return fail(fmt.Errorf("ftp data connection failed with %w", err))
+	}
+	mu.Lock()
+	conns = append(conns, data)
+	mu.Unlock()
+	if _, _, err := c.cmd(1, "RETR %s", file); err != nil {
+		data.Close()
+		return fail(fmt.Errorf("ftp RETR %s failed with %w", file, err))
+	}
+	if secure {
+		data = tls.Client(data, cfg)
+	}
+	r.Body = &ftpBody{ctx: ctx, data: data, c: c, stop: stop}
+	return r, nil
+}
+
+// passive asks for a passive data connection and returns its address, the host of the
+// control connection is used rather than the one a PASV reply names, which is often private
+func (c *ftpConn) passive(host string) (string, error) {
+	if _, msg, err := c.cmd(229, "EPSV"); err == nil {
+		// 229 Entering Extended Passive Mode (|||port|)
+		i, j := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if i >= 0 && j > i {
+			fields := strings.Split(msg[i+1:j], string(msg[i+1]))
+			if len(fields) == 5 {
+				if port, err := strconv.Atoi(fields[3]); err == nil {
+					return net.JoinHostPort(host, strconv.Itoa(port)), nil
+				}
+			}
+		}
+	}
+	_, msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return "", fmt.Errorf("ftp PASV failed with %w", err)
+	}
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	i := strings.IndexAny(msg, "0123456789")
+	j := strings.LastIndexAny(msg, "0123456789")
+	if i < 0 {
+		return "", fmt.Errorf("ftp PASV reply %q has no address", msg)
+	}
+	nums := strings.Split(msg[i:j+1], ",")
+	if len(nums) != 6 {
+		return "", fmt.Errorf("ftp PASV reply %q has no address", msg)
+	}
+	p1, err1 := strconv.Atoi(nums[4])
+	p2, err2 := strconv.Atoi(nums[5])
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("ftp PASV reply %q has no port", msg)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p1<<8|p2)), nil
+}
+
+// ftpBody reads the data connection of a transfer, which only succeeded once the server
+// confirms it on the control connection
+type ftpBody struct {
+	ctx  context.Context
+	data net.Conn
+	c    *ftpConn
+	stop chan struct{}
+	done bool
+}
+
+func (b *ftpBody) Read(p []byte) (int, error) {
+	n, err := b.data.Read(p)
+	if errors.Is(err, io.EOF) && !b.done {
+		b.done = true
+		if _, _, rerr := b.c.cmd(2, ""); rerr != nil {
+			return n, fmt.Errorf("ftp transfer failed with %w", rerr)
+		}
+	}
+	if err != nil && !errors.Is(err, io.EOF) && b.ctx.Err() != nil {
+		return n, b.ctx.Err()
+	}
+	return n, err
+}
+
+func (b *ftpBody) Close() error {
+	b.data.Close()
+	if b.done {
+		b.c.cmd(0, "QUIT")
+	}
+	close(b.stop)
+	b.c.close()
+	return nil
+}
@@ -0,0 +1,146 @@
+package gget
+
+import (
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ftpServer is an in-process ftp server of files in passive mode, upgrading to TLS with
+// AUTH TLS when it has a TLS config
+type ftpServer struct {
+	ln     net.Listener
+	files  map[string][]byte
+	mod    time.Time
+	tls    *tls.Config
+	mu     sync.Mutex
+	logins []string
+	rests  []int64
+}
+
+func newFTPServer(t *testing.T, files map[string][]byte, cfg *tls.Config) *ftpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ftpServer{ln: ln, files: files, mod: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), tls: cfg}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ftpServer) url(scheme, file string) string {
+	return scheme + "://" + s.ln.Addr().String() + "/" + file
+}
+
+func (s *ftpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) { text.PrintfLine(format, args...) }
+	var (
+		user    string
+		private bool
+		rest    int64
+		pasv    net.Listener
+	)
+	defer func() {
+		if pasv != nil {
+			pasv.Close()
+		}
+	}()
+	reply("220 test server ready")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "AUTH":
+			if s.tls == nil {
+				reply("502 no TLS")
+				continue
+			}
+			reply("234 go ahead")
+			conn = tls.Server(conn, s.tls)
+			text = textproto.NewConn(conn)
+		case "USER":
+			user = arg
+			reply("331 password please")
+		case "PASS":
+			s.mu.Lock()
+			s.logins = append(s.logins, user+":"+arg)
+			s.mu.Unlock()
+			reply("230 logged in")
+		case "PBSZ":
+			reply("200 ok")
+		case "PROT":
+			private = arg == "P"
+			reply("200 ok")
+		case "TYPE":
+			reply("200 binary")
+		case "SIZE", "MDTM":
+			data, ok := s.files[arg]
+			switch {
+			case !ok:
+				reply("550 no such file")
+			case cmd == "SIZE":
+				reply("213 %d", len(data))
+			default:
+				reply("213 %s", s.mod.Format("20060102150405"))
+			}
+		case "EPSV":
+			reply("502 not implemented")
+		case "PASV":
+			if pasv, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 no data connection")
+				continue
+			}
+			port := pasv.Addr().(*net.TCPAddr).Port
+			reply("227 Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff)
+		case "REST":
+			rest, _ = strconv.ParseInt(arg, 10, 64)
+			s.mu.Lock()
+			s.rests = append(s.rests, rest)
+			s.mu.Unlock()
+			reply("350 restarting")
+		case "RETR":
+			data, ok := s.files[arg]
+			if !ok || pasv == nil {
+				reply("550 no such file")
+				continue
+			}
+			dc, err := pasv.Accept()
+			if err != nil {
+				reply("425 no data connection")
+				continue
+			}
+			reply("150 sending")
+			if private {
+				dc = tls.Server(dc, s.tls)
+			}
+			dc.Write(data[rest:])
+			dc.Close()
+			rest = 0
+			reply("226 done")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
@@ -1,142 +1,60 @@
 package gget
 
 import (
-	"bufio"
 	"context"
-	"crypto/md5"
-	"fmt"
 	"io"
-	"mime"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
-	"strings"
-	"sync"
 )
 
-func Get(ctx context.Context, r io.Reader, routines int, outdir string) error {
-	_, err := os.ReadDir(outdir)
+// Get downloads the urls read from r into outdir using the given number of routines, failed
+// downloads are retried per the retry policy, it stops at the first url that still fails and
+// returns its *DownloadError, or the *InputError of the first invalid input entry
+func Get(ctx context.Context, r io.Reader, routines int, outdir string, opts ...Option) error {
+	_, err := Fetch(ctx, r, routines, outdir, opts...)
+	return err
+}
+
+// Job is a url to download
+type Job struct {
+	URL string
+	// Mirrors are other urls serving the same file
+	Mirrors []string
+	// Output is the path within outdir to save the file at, instead of the name the server gives
+	Output string
+	// Header holds extra headers sent with the requests of the url
+	Header http.Header
+	// Checksum the file must match, if set
+	Checksum *Checksum
+	// Priority orders the jobs waiting for a routine, higher first, in the order they were
+	// submitted among equals
+	Priority int
+}
+
+type job struct {
+	Job
+	index int
+	// err is set for an input entry that could not be parsed
+	err error
+	// depth is how many links away from an input url a crawled url is, root is that url
+	depth int
+	root  *url.URL
+	// h follows the job for the Downloader
+	h *JobHandle
+}
+
+// Fetch is Get returning the result of every url read. It stops at the first failure unless
+// WithContinueOnError is given, in which case every url is tried and the error only tells how
+// many failed.
+func Fetch(ctx context.Context, r io.Reader, routines int, outdir string, opts ...Option) (*Report, error) {
+	d, err := NewDownloader(ctx, outdir, append(opts, WithRoutines(routines))...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	errchan := make(chan error)
-	links := make(chan string)
-	go func() {
-		defer close(links)
-		scanner := bufio.NewScanner(r)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if scanner.Scan() {
-					link := strings.TrimSpace(scanner.Text())
-					_, err := url.ParseRequestURI(link)
-					if err != nil {
-						continue
-					}
-					links <- link
-				} else {
-					err := scanner.Err()
-					if err != nil {
-						errchan <- err
-					}
-					return
-				}
-			}
-		}
-	}()
-	var wg sync.WaitGroup
-	go func() {
-		defer close(errchan)
-		wg.Wait()
-	}()
-	doneCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	cli := http.DefaultClient
-	for j := 0; j < routines; j++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for {
-				select {
-				case link, ok := <-links:
-					if !ok {
-						cancel()
-						return
-					}
-					req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
-					if err != nil {
-						errchan <- err
-					}
-					resp, err := cli.Do(req)
-					if err != nil {
-						errchan <- err
-						cancel()
-						return
-					}
-					defer func() {
-						err := resp.Body.Close()
-						if err != nil {
-							errchan <- fmt.Errorf("resp body close %s failed with %v", link, err)
-							cancel()
-							return
-						}
-					}()
-					cd := resp.Header.Get("Content-Disposition")
-					mediatype, params, err := mime.ParseMediaType(cd)
-					_ = mediatype
-					var filename string
-					if err == nil {
-						filename = params["filename"]
-					} else {
-						h := md5.New()
-						io.WriteString(h, link)
-						filename = fmt.Sprintf("%x", h.Sum(nil))
-						ct := resp.Header.Get("Content-Type")
-						if ct != "" {
-							exts, err := mime.ExtensionsByType(ct)
-							if err == nil {
-								filename = fmt.Sprintf("%x%s", h.Sum(nil), exts[0])
-							}
-						}
-					}
-					path := filepath.Join(outdir, filename)
-					f, err := os.Create(path)
-					if err != nil {
-						errchan <- fmt.Errorf("file create %s failed with %v", path, err)
-						cancel()
-						return
-					}
-					defer func() {
-						err := f.Close()
-						if err != nil {
-							errchan <- fmt.Errorf("file close %s failed with %v", path, err)
-							cancel()
-							return
-						}
-					}()
-					_, err = io.Copy(f, resp.Body)
-					if err == nil {
-						err := f.Sync()
-						if err != nil {
-							errchan <- fmt.Errorf("file create %s failed with %v", path, err)
-							cancel()
-							return
-						}
-					}
-					if err != nil {
-						errchan <- err
-						cancel()
-						return
-					}
-				case <-doneCtx.Done():
-					return
-				}
-			}
-		}()
+	readErr := d.SubmitFrom(r)
+	rep, err := d.Close()
+	if readErr != nil {
+		return rep, readErr
 	}
-
-	return <-errchan
+	return rep, err
 }
@@ -0,0 +1,85 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var payload = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+func serveFile(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(payload))
+	}
+}
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", serveFile("a.bin"))
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/b", serveFile("b.bin"))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	input := strings.Join([]string{srv.URL + "/a", srv.URL + "/missing", "not a url", srv.URL + "/b"}, "\n")
+
+	t.Run("test continue on error", func(t *testing.T) {
+		outdir := t.TempDir()
+		rep, err := Fetch(context.Background(), strings.NewReader(input), 1, outdir, WithContinueOnError())
+		if err == nil {
+			t.Error("expected an error for the failed url")
+		}
+		if len(rep.Results) != 4 || rep.Failed() != 2 {
+			t.Fatalf("wanted 4 results with 2 failures but got %+v", rep.Results)
+		}
+		for i, want := range []string{srv.URL + "/a", srv.URL + "/missing", "not a url", srv.URL + "/b"} {
+			if res := rep.Results[i]; res.URL != want {
+				t.Errorf("result %d: wanted %s but got %s", i, want, res.URL)
+			}
+		}
+		var ie *InputError
+		if res := rep.Results[2]; !errors.As(res.Err, &ie) || ie.Line != 3 {
+			t.Errorf("expected the invalid line to be reported with its number but got %v", res.Err)
+		}
+		if res := rep.Results[3]; !res.OK() || res.Path != filepath.Join(outdir, "b.bin") || res.Bytes != int64(len(payload)) || res.StatusCode != http.StatusOK {
+			t.Errorf("unexpected result %+v", res)
+		}
+		if res := rep.Results[1]; res.StatusCode != http.StatusNotFound || len(res.Attempts) != 1 {
+			t.Errorf("unexpected result %+v", res)
+		}
+
+		var buf bytes.Buffer
+		if err := rep.WriteJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), `"error": "get `+srv.URL+`/missing failed with status 404 Not Found"`) {
+			t.Errorf("json report misses the error:\n%s", buf.String())
+		}
+		buf.Reset()
+		if err := rep.WriteTable(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "2 downloaded, 2 failed") {
+			t.Errorf("table misses the summary:\n%s", buf.String())
+		}
+	})
+
+	t.Run("test stop at first error", func(t *testing.T) {
+		rep, err := Fetch(context.Background(), strings.NewReader(input), 1, t.TempDir())
+		var se *StatusError
+		if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+			t.Fatalf("wanted the 404 but got %v", err)
+		}
+		if len(rep.Results) > 2 {
+			t.Errorf("urls after the failure should not be downloaded, got %d results", len(rep.Results))
+		}
+	})
+}
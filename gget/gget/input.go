@@ -0,0 +1,417 @@
+package gget
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// InputFormat is how the urls given to Fetch are written
+type InputFormat int
+
+const (
+	// InputAuto detects the format from the first character, { for JSON Lines, < for
+	// Metalink and lines otherwise
+	InputAuto InputFormat = iota
+	// InputLines is a url per line, followed by mirror urls and checksums as algo=hex, and
+	// optionally by indented aria2-style options out=, header= and checksum=
+	InputLines
+	// InputJSONLines is a JSON object per line with url, output, headers, checksum and mirrors
+	InputJSONLines
+	// InputMetalink is a Metalink 4 document as in RFC 5854
+	InputMetalink
+)
+
+var inputFormatNames = []string{"auto", "lines", "jsonl", "metalink"}
+
+func (f InputFormat) String() string {
+	if f < 0 || int(f) >= len(inputFormatNames) {
+		return fmt.Sprintf("InputFormat(%d)", int(f))
+	}
+	return inputFormatNames[f]
+}
+
+// ParseInputFormat parses auto, lines, jsonl or metalink
+func ParseInputFormat(s string) (InputFormat, error) {
+	for i, name := range inputFormatNames {
+		if s == name {
+			return InputFormat(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown input format %q, expected one of %s", s, strings.Join(inputFormatNames, ", "))
+}
+
+// InputError is the failure of an input entry that could not be parsed, it is reported
+// as the result of the entry
+type InputError struct {
+	Line int
+	Err  error
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("input line %d: %v", e.Line, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// readJobs reads the jobs of r written in format, calling add with each job, or with the
+// InputError of an invalid entry and what it could tell of it, until r is done or ctx is
+func readJobs(ctx context.Context, r io.Reader, format InputFormat, add func(Job, error)) error {
+	br := bufio.NewReader(r)
+	if format == InputAuto {
+		format = detectFormat(br)
+	}
+	switch format {
+	case InputJSONLines:
+		return readJSONLines(ctx, br, add)
+	case InputMetalink:
+		return readMetalink(ctx, br, add)
+	}
+	return readLines(ctx, br, add)
+}
+
+// detectFormat peeks at the first character of r that is not a space
+func detectFormat(r *bufio.Reader) InputFormat {
+	for n := 1; ; n++ {
+		b, _ := r.Peek(n)
+		if len(b) < n {
+			return InputLines
+		}
+		switch b[n-1] {
+		case '{':
+			return InputJSONLines
+		case '<':
+			return InputMetalink
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return InputLines
+	}
+}
+
+// parseLine parses an input line, a url optionally followed by mirror urls and checksums as in
+// "https://example.com/file.tar.gz https://mirror.example.com/file.tar.gz sha256=<hex>"
+func parseLine(line string) (Job, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Job{}, fmt.Errorf("empty line")
+	}
+	j := Job{URL: fields[0]}
+	if err := checkURL(j.URL); err != nil {
+		return Job{URL: line}, err
+	}
+	for _, field := range fields[1:] {
+		if strings.Contains(field, "://") {
+			if err := checkURL(field); err != nil {
+				return j, err
+			}
+			j.Mirrors = append(j.Mirrors, field)
+			continue
+		}
+		c, err := ParseChecksum(field)
+		if err != nil {
+			return j, err
+		}
+		j.Checksum = c
+	}
+	return j, nil
+}
+
+func checkURL(link string) error {
+	u, err := url.ParseRequestURI(link)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" {
+		return fmt.Errorf("url %q has no scheme", link)
+	}
+	return nil
+}
+
+// readLines reads urls a line at a time, an entry is a url line and the indented option lines
+// following it, lines starting with # are comments
+func readLines(ctx context.Context, r io.Reader, add func(Job, error)) error {
+	var (
+		cur     *Job
+		curErr  error
+		scanner = bufio.NewScanner(r)
+	)
+	flush := func() {
+		if cur != nil {
+			add(*cur, curErr)
+		}
+		cur, curErr = nil, nil
+	}
+	for n := 1; scanner.Scan() && ctx.Err() == nil; n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			switch {
+			case cur == nil:
+				add(Job{URL: trimmed}, &InputError{Line: n, Err: errors.New("option without a url before it")})
+			case curErr == nil:
+				if err := applyOption(cur, trimmed); err != nil {
+					curErr = &InputError{Line: n, Err: err}
+				}
+			}
+			continue
+		}
+		flush()
+		j, err := parseLine(line)
+		cur = &j
+		if err != nil {
+			curErr = &InputError{Line: n, Err: err}
+		}
+	}
+	flush()
+	return scanner.Err()
+}
+
+// applyOption sets the aria2-style option name=value on j
+func applyOption(j *Job, opt string) error {
+	name, value, ok := strings.Cut(opt, "=")
+	if !ok {
+		return fmt.Errorf("invalid option %q, want name=value", opt)
+	}
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	switch name {
+	case "out":
+		out, err := cleanOutput(value)
+		if err != nil {
+			return err
+		}
+		j.Output = out
+	case "header":
+		k, v, ok := strings.Cut(value, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q, want Name: value", value)
+		}
+		if j.Header == nil {
+			j.Header = http.Header{}
+		}
+		j.Header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	case "checksum":
+		c, err := ParseChecksum(value)
+		if err != nil {
+			return err
+		}
+		j.Checksum = c
+	default:
+		return fmt.Errorf("unsupported option %q", name)
+	}
+	return nil
+}
+
+// cleanOutput checks an output name given in the input, a relative path within outdir
+func cleanOutput(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if slashed == "" || path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("output %q must be a relative path", name)
+	}
+	for _, elem := range strings.Split(slashed, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("output %q must not leave the output directory", name)
+		}
+	}
+	return filepath.FromSlash(path.Clean(slashed)), nil
+}
+
+// jsonHeader accepts header values as a string or a list of strings
+type jsonHeader http.Header
+
+func (h *jsonHeader) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*h = jsonHeader{}
+	for k, v := range raw {
+		var one string
+		if err := json.Unmarshal(v, &one); err == nil {
+			http.Header(*h).Add(k, one)
+			continue
+		}
+		var many []string
+		if err := json.Unmarshal(v, &many); err != nil {
+			return fmt.Errorf("header %s must be a string or a list of strings", k)
+		}
+		for _, s := range many {
+			http.Header(*h).Add(k, s)
+		}
+	}
+	return nil
+}
+
+type jsonJob struct {
+	URL      string     `json:"url"`
+	Output   string     `json:"output"`
+	Headers  jsonHeader `json:"headers"`
+	Checksum string     `json:"checksum"`
+	Mirrors  []string   `json:"mirrors"`
+}
+
+// readJSONLines reads a JSON object per line, blank lines are skipped
+func readJSONLines(ctx context.Context, r io.Reader, add func(Job, error)) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan() && ctx.Err() == nil; n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		j, err := parseJSONLine(line)
+		if err != nil {
+			err = &InputError{Line: n, Err: err}
+		}
+		add(j, err)
+	}
+	return scanner.Err()
+}
+
+func parseJSONLine(line string) (Job, error) {
+	var jj jsonJob
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&jj); err != nil {
+		return Job{URL: line}, err
+	}
+	j := Job{URL: jj.URL, Header: http.Header(jj.Headers), Mirrors: jj.Mirrors}
+	if jj.URL == "" {
+		return Job{URL: line}, errors.New("url is missing")
+	}
+	if err := checkURL(j.URL); err != nil {
+		return j, err
+	}
+	for _, m := range j.Mirrors {
+		if err := checkURL(m); err != nil {
+			return j, err
+		}
+	}
+	if jj.Output != "" {
+		out, err := cleanOutput(jj.Output)
+		if err != nil {
+			return j, err
+		}
+		j.Output = out
+	}
+	if jj.Checksum != "" {
+		c, err := ParseChecksum(jj.Checksum)
+		if err != nil {
+			return j, err
+		}
+		j.Checksum = c
+	}
+	return j, nil
+}
+
+// metalinkHashes are the Metalink hash types gget verifies, strongest first
+var metalinkHashes = []string{"sha-512", "sha-256", "sha-1", "md5"}
+
+type metalinkURL struct {
+	Priority int    `xml:"priority,attr"`
+	URL      string `xml:",chardata"`
+}
+
+type metalinkHash struct {
+	Type string `xml:"type,attr"`
+	Sum  string `xml:",chardata"`
+}
+
+type metalinkFile struct {
+	Name   string         `xml:"name,attr"`
+	Hashes []metalinkHash `xml:"hash"`
+	URLs   []metalinkURL  `xml:"url"`
+}
+
+// readMetalink reads the files of a Metalink 4 document, each becomes a job of its urls by
+// priority with the first as the url and the others as mirrors
+func readMetalink(ctx context.Context, r io.Reader, add func(Job, error)) error {
+	dec := xml.NewDecoder(r)
+	var root bool
+	for ctx.Err() == nil {
+		line, _ := dec.InputPos()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			if !root {
+				return errors.New("metalink element is missing")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("metalink parse failed with %v", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "metalink":
+			root = true
+		case "file":
+			var f metalinkFile
+			if err := dec.DecodeElement(&f, &se); err != nil {
+				return fmt.Errorf("metalink parse failed with %v", err)
+			}
+			j, err := metalinkJob(f)
+			if err != nil {
+				err = &InputError{Line: line, Err: err}
+			}
+			add(j, err)
+		}
+	}
+	return nil
+}
+
+func metalinkJob(f metalinkFile) (Job, error) {
+	j := Job{URL: f.Name}
+	if len(f.URLs) == 0 {
+		return j, fmt.Errorf("file %q has no url", f.Name)
+	}
+	// a priority of 1 comes first, urls without one last
+	sort.SliceStable(f.URLs, func(a, b int) bool {
+		pa, pb := f.URLs[a].Priority, f.URLs[b].Priority
+		return pa != 0 && (pb == 0 || pa < pb)
+	})
+	for i, u := range f.URLs {
+		link := strings.TrimSpace(u.URL)
+		if err := checkURL(link); err != nil {
+			return j, err
+		}
+		if i == 0 {
+			j.URL = link
+		} else {
+			j.Mirrors = append(j.Mirrors, link)
+		}
+	}
+	out, err := cleanOutput(f.Name)
+	if err != nil {
+		return j, err
+	}
+	j.Output = out
+	for _, typ := range metalinkHashes {
+		for _, h := range f.Hashes {
+			if strings.EqualFold(h.Type, typ) && j.Checksum == nil {
+				c, err := NewChecksum(typ, strings.TrimSpace(h.Sum))
+				if err != nil {
+					return j, err
+				}
+				j.Checksum = c
+			}
+		}
+	}
+	return j, nil
+}
@@ -0,0 +1,135 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInput(t *testing.T) {
+	sum := fmt.Sprintf("%x", sha256.Sum256(payload))
+	read := func(t *testing.T, in string, format InputFormat) ([]Job, []error) {
+		var (
+			jobs []Job
+			errs []error
+		)
+		err := readJobs(context.Background(), strings.NewReader(in), format, func(j Job, err error) {
+			jobs = append(jobs, j)
+			errs = append(errs, err)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobs, errs
+	}
+	line := func(err error) int {
+		var ie *InputError
+		if !errors.As(err, &ie) {
+			return 0
+		}
+		return ie.Line
+	}
+
+	t.Run("test lines with options", func(t *testing.T) {
+		in := "# nightly\n" +
+			"http://a/f.tgz\thttp://b/f.tgz sha256=" + sum + "\n" +
+			"  out=sub/g.tgz\n" +
+			"  header=Authorization: Bearer x\n" +
+			"\n" +
+			"http://a/h\n" +
+			"  dir=/tmp\n" +
+			"ftp\n"
+		jobs, errs := read(t, in, InputAuto)
+		if len(jobs) != 3 {
+			t.Fatalf("wanted 3 entries but got %+v", jobs)
+		}
+		j := jobs[0]
+		if errs[0] != nil || j.URL != "http://a/f.tgz" || len(j.Mirrors) != 1 || j.Mirrors[0] != "http://b/f.tgz" ||
+			j.Output != filepath.Join("sub", "g.tgz") || j.Header.Get("Authorization") != "Bearer x" || j.Checksum == nil {
+			t.Errorf("unexpected job %+v, %v", j, errs[0])
+		}
+		if line(errs[1]) != 7 || jobs[1].URL != "http://a/h" {
+			t.Errorf("wanted an error on line 7 for %s but got %v", jobs[1].URL, errs[1])
+		}
+		if line(errs[2]) != 8 {
+			t.Errorf("wanted an error on line 8 but got %v", errs[2])
+		}
+	})
+
+	t.Run("test json lines", func(t *testing.T) {
+		in := `{"url": "http://a/f", "output": "f.bin", "headers": {"X-One": "1", "X-Many": ["a", "b"]}, "checksum": "sha256:` + sum + `", "mirrors": ["http://b/f"]}` + "\n" +
+			`{"url": "http://a/g", "ouput": "typo"}` + "\n" +
+			`{"url": "http://a/h", "output": "../h"}` + "\n" +
+			`not json` + "\n"
+		jobs, errs := read(t, in, InputAuto)
+		if len(jobs) != 4 {
+			t.Fatalf("wanted 4 entries but got %+v", jobs)
+		}
+		j := jobs[0]
+		if errs[0] != nil || j.Output != "f.bin" || j.Header.Get("X-One") != "1" || len(j.Header.Values("X-Many")) != 2 || j.Checksum == nil || len(j.Mirrors) != 1 {
+			t.Errorf("unexpected job %+v, %v", j, errs[0])
+		}
+		for i, want := range []int{0, 2, 3, 4} {
+			if got := line(errs[i]); got != want {
+				t.Errorf("entry %d: wanted an error on line %d but got %v", i, want, errs[i])
+			}
+		}
+	})
+
+	t.Run("test metalink", func(t *testing.T) {
+		in := `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="dir/f.iso">
+    <size>65536</size>
+    <hash type="md5">` + fmt.Sprintf("%x", md5.Sum(payload)) + `</hash>
+    <hash type="sha-256">` + sum + `</hash>
+    <url>http://c/f.iso</url>
+    <url priority="2">http://b/f.iso</url>
+    <url location="de" priority="1">http://a/f.iso</url>
+  </file>
+  <file name="../escape">
+    <url>http://a/e</url>
+  </file>
+</metalink>`
+		jobs, errs := read(t, in, InputAuto)
+		if len(jobs) != 2 {
+			t.Fatalf("wanted 2 entries but got %+v", jobs)
+		}
+		j := jobs[0]
+		if errs[0] != nil || j.URL != "http://a/f.iso" || strings.Join(j.Mirrors, " ") != "http://b/f.iso http://c/f.iso" ||
+			j.Output != filepath.Join("dir", "f.iso") || j.Checksum == nil || j.Checksum.Algo != "sha256" {
+			t.Errorf("unexpected job %+v, %v", j, errs[0])
+		}
+		if line(errs[1]) != 11 {
+			t.Errorf("wanted an error on line 11 but got %v", errs[1])
+		}
+	})
+
+	t.Run("test output and headers are used", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Token") != "secret" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			serveFile("served.bin")(w, r)
+		}))
+		defer srv.Close()
+		dir := t.TempDir()
+		in := `{"url": "` + srv.URL + `/f", "output": "sub/named.bin", "headers": {"X-Token": "secret"}}`
+		if err := Get(context.Background(), strings.NewReader(in), 1, dir); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(filepath.Join(dir, "sub", "named.bin")); err != nil || !bytes.Equal(b, payload) {
+			t.Errorf("expected the file at its output name, %v", err)
+		}
+	})
+}
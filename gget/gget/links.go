@@ -0,0 +1,264 @@
+package gget
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// link is a reference to a url in a page, found at data[start:end] of the page
+type link struct {
+	start, end int
+	// ref is the reference with entities decoded, relative to the page or its base
+	ref string
+}
+
+// linkAttrs are the attributes of html elements that reference urls to fetch
+var linkAttrs = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"srcset":     true,
+	"poster":     true,
+	"background": true,
+	"data":       true,
+}
+
+// rawTextTags are the elements whose content is not markup
+var rawTextTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+	"xmp":      true,
+}
+
+// htmlLinks returns the links of an html page and the href of its base element, if any.
+// With css, stylesheets in style elements and attributes are searched too.
+func htmlLinks(data []byte, css bool) (links []link, base string) {
+	for i := 0; i < len(data); {
+		lt := bytes.IndexByte(data[i:], '<')
+		if lt < 0 {
+			break
+		}
+		i += lt
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("<!--")):
+			i = skipPast(data, i+4, "-->")
+			continue
+		case bytes.HasPrefix(data[i:], []byte("<!")), bytes.HasPrefix(data[i:], []byte("<?")), bytes.HasPrefix(data[i:], []byte("</")):
+			i = skipPast(data, i+2, ">")
+			continue
+		}
+		name, attrs, next := parseTag(data, i+1)
+		if name == "" {
+			i++
+			continue
+		}
+		i = next
+		for _, a := range attrs {
+			switch {
+			case name == "base" && a.name == "href":
+				if base == "" {
+					base = html.UnescapeString(strings.TrimSpace(string(data[a.start:a.end])))
+				}
+			case a.name == "srcset":
+				links = append(links, srcsetLinks(data, a.start, a.end)...)
+			case a.name == "data" && name != "object":
+			case linkAttrs[a.name]:
+				links = appendLink(links, data, a.start, a.end)
+			case a.name == "style" && css:
+				links = append(links, cssLinks(data[:a.end], a.start)...)
+			}
+		}
+		if rawTextTags[name] {
+			end := indexFold(data[i:], "</"+name)
+			if end < 0 {
+				end = len(data) - i
+			}
+			if name == "style" && css {
+				links = append(links, cssLinks(data[:i+end], i)...)
+			}
+			i += end
+		}
+	}
+	return links, base
+}
+
+// tagAttr is the name of an attribute and where its value is
+type tagAttr struct {
+	name       string
+	start, end int
+}
+
+// parseTag parses the tag starting at data[i] after its '<', returning its lowercase name,
+// its attributes and the offset past its '>', the name is empty when it is not a tag
+func parseTag(data []byte, i int) (name string, attrs []tagAttr, next int) {
+	start := i
+	for i < len(data) && isNameByte(data[i]) {
+		i++
+	}
+	if i == start || !isLetter(data[start]) {
+		return "", nil, start
+	}
+	name = strings.ToLower(string(data[start:i]))
+	for i < len(data) {
+		i = skipSpace(data, i)
+		if i >= len(data) {
+			break
+		}
+		if data[i] == '>' {
+			return name, attrs, i + 1
+		}
+		if data[i] == '/' {
+			i++
+			continue
+		}
+		an := i
+		for i < len(data) && !isSpace(data[i]) && data[i] != '=' && data[i] != '>' && data[i] != '/' {
+			i++
+		}
+		a := tagAttr{name: strings.ToLower(string(data[an:i]))}
+		i = skipSpace(data, i)
+		if i >= len(data) || data[i] != '=' {
+			continue
+		}
+		i = skipSpace(data, i+1)
+		if i >= len(data) {
+			break
+		}
+		if q := data[i]; q == '"' || q == '\'' {
+			end := bytes.IndexByte(data[i+1:], q)
+			if end < 0 {
+				return name, attrs, len(data)
+			}
+			a.start, a.end = i+1, i+1+end
+			i = a.end + 1
+		} else {
+			a.start = i
+			for i < len(data) && !isSpace(data[i]) && data[i] != '>' {
+				i++
+			}
+			a.end = i
+		}
+		attrs = append(attrs, a)
+	}
+	return name, attrs, len(data)
+}
+
+// srcsetLinks returns the urls of the image candidates of the srcset value data[start:end]
+func srcsetLinks(data []byte, start, end int) []link {
+	var links []link
+	for i := start; i < end; {
+		for i < end && (isSpace(data[i]) || data[i] == ',') {
+			i++
+		}
+		s := i
+		for i < end && !isSpace(data[i]) {
+			i++
+		}
+		e := i
+		// a url may end in a comma only when no descriptor follows
+		if e > s && data[e-1] == ',' {
+			e--
+		}
+		if e > s {
+			links = appendLink(links, data, s, e)
+		}
+		for i < end && data[i] != ',' {
+			i++
+		}
+	}
+	return links
+}
+
+// cssLinks returns the url() and @import references of the stylesheet data[start:]
+func cssLinks(data []byte, start int) []link {
+	var links []link
+	for i := start; i < len(data); i++ {
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			i = skipPast(data, i+2, "*/") - 1
+		case hasPrefixFold(data[i:], "url("):
+			j := skipSpace(data, i+4)
+			if j < len(data) && (data[j] == '"' || data[j] == '\'') {
+				end := bytes.IndexByte(data[j+1:], data[j])
+				if end < 0 {
+					return links
+				}
+				links = appendLink(links, data, j+1, j+1+end)
+				i = j + 1 + end
+				continue
+			}
+			end := bytes.IndexByte(data[j:], ')')
+			if end < 0 {
+				return links
+			}
+			e := j + end
+			for e > j && isSpace(data[e-1]) {
+				e--
+			}
+			links = appendLink(links, data, j, e)
+			i = j + end
+		case hasPrefixFold(data[i:], "@import"):
+			j := skipSpace(data, i+7)
+			if j < len(data) && (data[j] == '"' || data[j] == '\'') {
+				end := bytes.IndexByte(data[j+1:], data[j])
+				if end < 0 {
+					return links
+				}
+				links = appendLink(links, data, j+1, j+1+end)
+				i = j + 1 + end
+			}
+		}
+	}
+	return links
+}
+
+func appendLink(links []link, data []byte, start, end int) []link {
+	ref := html.UnescapeString(strings.TrimSpace(string(data[start:end])))
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return links
+	}
+	return append(links, link{start: start, end: end, ref: ref})
+}
+
+// skipPast returns the offset past the first sep in data[i:], or len(data)
+func skipPast(data []byte, i int, sep string) int {
+	end := bytes.Index(data[i:], []byte(sep))
+	if end < 0 {
+		return len(data)
+	}
+	return i + end + len(sep)
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && isSpace(data[i]) {
+		i++
+	}
+	return i
+}
+
+func indexFold(data []byte, s string) int {
+	for i := 0; i+len(s) <= len(data); i++ {
+		if hasPrefixFold(data[i:], s) {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasPrefixFold(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && strings.EqualFold(string(data[:len(prefix)]), prefix)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameByte(c byte) bool {
+	return isLetter(c) || c >= '0' && c <= '9' || c == '-' || c == ':'
+}
@@ -0,0 +1,178 @@
+package gget
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// mirrorIndex is the file in outdir recording the validators of the mirrored urls
+const mirrorIndex = ".gget-mirror.json"
+
+// mirrorSaveInterval is how often the index is written at most while downloading, what is
+// recorded meanwhile is written once it runs out or by flush
+var mirrorSaveInterval = 5 * time.Second
+
+// mirrorEntry is what a url was last downloaded as
+type mirrorEntry struct {
+	// Name is the path of the file within outdir
+	Name         string `json:"name"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// conditions returns the headers asking to send the url only when it changed
+func (e *mirrorEntry) conditions() http.Header {
+	h := http.Header{}
+	if e.ETag != "" {
+		h.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		h.Set("If-Modified-Since", e.LastModified)
+	}
+	return h
+}
+
+// mirror keeps the index of outdir for mirror mode, its methods do nothing on a nil mirror
+type mirror struct {
+	outdir string
+
+	mu      sync.Mutex
+	entries map[string]*mirrorEntry
+	dirty   bool
+	saved   time.Time
+	pending *time.Timer
+}
+
+// loadMirror reads the index of outdir, which is empty on the first run
+func loadMirror(outdir string) (*mirror, error) {
+	m := &mirror{outdir: outdir, entries: make(map[string]*mirrorEntry)}
+	b, err := os.ReadFile(filepath.Join(outdir, mirrorIndex))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m.entries); err != nil {
+		return nil, fmt.Errorf("mirror index %s parse failed with %v", mirrorIndex, err)
+	}
+	return m, nil
+}
+
+// lookup returns the entry of link whose file is still in outdir and has validators
+func (m *mirror) lookup(link string) *mirrorEntry {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	e := m.entries[link]
+	m.mu.Unlock()
+	if e == nil || (e.ETag == "" && e.LastModified == "") {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(m.outdir, e.Name)); err != nil {
+		return nil
+	}
+	return e
+}
+
+// owns reports whether the file at path is the earlier download of j, from its url or one of
+// its mirrors, which it replaces whatever the conflict policy
+func (m *mirror) owns(j Job, path string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, src := range j.sources() {
+		if e := m.entries[src]; e != nil && filepath.Join(m.outdir, e.Name) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// record notes the validators link was downloaded to path with and sets the modification
+// time of the file to its Last-Modified, the index is written once mirrorSaveInterval passed
+// since it was last
+func (m *mirror) record(link, path string, st *partState) error {
+	if m == nil {
+		return nil
+	}
+	if t, err := http.ParseTime(st.LastModified); err == nil {
+		if err := os.Chtimes(path, time.Now(), t); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, err := filepath.Rel(m.outdir, path)
+	if err != nil {
+		return err
+	}
+	m.entries[link] = &mirrorEntry{Name: name, ETag: st.ETag, LastModified: st.LastModified}
+	m.dirty = true
+	if wait := mirrorSaveInterval - time.Since(m.saved); wait > 0 {
+		if m.pending == nil {
+			m.pending = time.AfterFunc(wait, func() {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				m.pending = nil
+				if m.dirty {
+					// a failed write is left to the next record or flush
+					m.save()
+				}
+			})
+		}
+		return nil
+	}
+	return m.save()
+}
+
+// flush writes the index if anything was recorded since it was last
+func (m *mirror) flush() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+	return m.save()
+}
+
+// save writes the index, m.mu must be held
+func (m *mirror) save() error {
+	b, err := json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// replaced whole so an interrupted run never leaves a torn index
+	tmp := filepath.Join(m.outdir, mirrorIndex+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(m.outdir, mirrorIndex)); err != nil {
+		return err
+	}
+	if m.pending != nil {
+		m.pending.Stop()
+		m.pending = nil
+	}
+	m.dirty, m.saved = false, time.Now()
+	return nil
+}
+
+// notModified fills res for a url the server reported unchanged since e
+func (m *mirror) notModified(e *mirrorEntry, resp *http.Response, res *Result) error {
+	res.StatusCode = resp.StatusCode
+	return skipped(filepath.Join(m.outdir, e.Name), res)
+}
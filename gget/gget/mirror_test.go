@@ -0,0 +1,149 @@
+package gget
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		version = "v1"
+		bodies  int
+	)
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		v := version
+		mu.Unlock()
+		if r.URL.Path == "/etag.txt" {
+			w.Header().Set("ETag", `"`+v+`"`)
+		}
+		// the other file is only validated by its modification time
+		lm := modified
+		if v != "v1" {
+			lm = lm.Add(time.Hour)
+		}
+		if r.Method == http.MethodGet && r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" {
+			mu.Lock()
+			bodies++
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "", lm, strings.NewReader(v+r.URL.Path))
+	}))
+	defer srv.Close()
+	input := fmt.Sprintf("%s/etag.txt\n%s/modified.txt\n", srv.URL, srv.URL)
+	dir := t.TempDir()
+	run := func(t *testing.T) *Report {
+		mu.Lock()
+		bodies = 0
+		mu.Unlock()
+		rep, err := Fetch(context.Background(), strings.NewReader(input), 2, dir, WithMirror(), WithConflict(ConflictRename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rep
+	}
+	check := func(t *testing.T, want string, lm time.Time) {
+		for _, name := range []string{"etag.txt", "modified.txt"} {
+			p := filepath.Join(dir, name)
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != want+"/"+name {
+				t.Errorf("wanted %s of %s but got %q", want, name, b)
+			}
+			if fi, _ := os.Stat(p); !fi.ModTime().Equal(lm) {
+				t.Errorf("wanted %s modified at %v but got %v", name, lm, fi.ModTime())
+			}
+		}
+	}
+
+	t.Run("test first run downloads", func(t *testing.T) {
+		run(t)
+		check(t, "v1", modified)
+	})
+
+	t.Run("test unchanged files are skipped", func(t *testing.T) {
+		rep := run(t)
+		for _, res := range rep.Results {
+			if !res.Skipped || res.StatusCode != http.StatusNotModified {
+				t.Errorf("expected %s not modified but got %d", res.URL, res.StatusCode)
+			}
+		}
+		if bodies != 0 {
+			t.Errorf("expected no unconditional downloads but got %d", bodies)
+		}
+		check(t, "v1", modified)
+	})
+
+	t.Run("test changed files are downloaded again in place", func(t *testing.T) {
+		mu.Lock()
+		version = "v2"
+		mu.Unlock()
+		rep := run(t)
+		for _, res := range rep.Results {
+			if res.Skipped {
+				t.Errorf("expected %s to be downloaded again", res.URL)
+			}
+		}
+		check(t, "v2", modified.Add(time.Hour))
+	})
+
+	t.Run("test index written at intervals and on flush", func(t *testing.T) {
+		dir := t.TempDir()
+		m, err := loadMirror(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := func() int {
+			t.Helper()
+			on, err := loadMirror(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return len(on.entries)
+		}
+		for i := 0; i < 3; i++ {
+			p := filepath.Join(dir, fmt.Sprint("f", i))
+			if err := os.WriteFile(p, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.record(fmt.Sprint("http://h/f", i), p, &partState{ETag: `"x"`}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := saved(); n != 1 {
+			t.Errorf("expected only the first record to be written before the interval but got %d", n)
+		}
+		if err := m.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if n := saved(); n != 3 {
+			t.Errorf("expected every record to be written by flush but got %d", n)
+		}
+
+		// what is recorded within the interval is written once it runs out
+		defer func(d time.Duration) { mirrorSaveInterval = d }(mirrorSaveInterval)
+		mirrorSaveInterval = 50 * time.Millisecond
+		m.mu.Lock()
+		m.saved = time.Now()
+		m.mu.Unlock()
+		if err := m.record("http://h/f0", filepath.Join(dir, "f0"), &partState{ETag: `"y"`}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(4 * mirrorSaveInterval)
+		if on, err := loadMirror(dir); err != nil || on.entries["http://h/f0"].ETag != `"y"` {
+			t.Errorf("expected the record to be written after the interval: %v", err)
+		}
+	})
+}
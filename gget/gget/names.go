@@ -0,0 +1,272 @@
+package gget
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Conflict is what to do when the file a url is saved as already exists
+type Conflict int
+
+const (
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite Conflict = iota
+	// ConflictSkip keeps the existing file and does not download the url
+	ConflictSkip
+	// ConflictRename saves the url under the name with a -1, -2, ... suffix before the extension
+	ConflictRename
+	// ConflictError fails the url with ErrExists
+	ConflictError
+)
+
+var conflictNames = []string{"overwrite", "skip", "rename", "error"}
+
+func (c Conflict) String() string {
+	if c < 0 || int(c) >= len(conflictNames) {
+		return fmt.Sprintf("Conflict(%d)", int(c))
+	}
+	return conflictNames[c]
+}
+
+// ParseConflict parses overwrite, skip, rename or error
+func ParseConflict(s string) (Conflict, error) {
+	for i, name := range conflictNames {
+		if s == name {
+			return Conflict(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown conflict policy %q, expected one of %s", s, strings.Join(conflictNames, ", "))
+}
+
+// ErrExists is returned under ConflictError for a url whose file already exists
+var ErrExists = errors.New("file exists")
+
+// claims tracks which url of a batch each output path belongs to, so two urls named alike
+// never share a file, whatever the conflict policy
+type claims struct {
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+// target returns the path the response of j with header is saved to under the conflict policy,
+// skip is set when the url should not be downloaded because the file exists. The name the
+// server gives the file is recorded in res for looking up its checksum.
+func (b *batch) target(j Job, header http.Header, res *Result) (p string, skip bool, err error) {
+	link := j.URL
+	index := res.index
+	res.remote = filename(link, header)
+	name, err := b.outputName(j, header)
+	if err != nil {
+		return "", false, err
+	}
+	if dir := filepath.Dir(name); dir != "." {
+		if err := os.MkdirAll(filepath.Join(b.outdir, dir), 0o755); err != nil {
+			return "", false, err
+		}
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	b.claims.mu.Lock()
+	defer b.claims.mu.Unlock()
+	p = filepath.Join(b.outdir, name)
+	for n := 1; ; n++ {
+		owner, claimed := b.claims.paths[p]
+		if claimed && owner == index {
+			// an earlier attempt of the same url
+			return p, false, nil
+		}
+		_, err := os.Lstat(p)
+		exists := err == nil
+		if !claimed && (!exists || b.cfg.conflict == ConflictOverwrite || b.mirror.owns(j, p)) {
+			break
+		}
+		switch b.cfg.conflict {
+		case ConflictSkip:
+			return p, true, nil
+		case ConflictError:
+			return "", false, fmt.Errorf("%w: %s for %s", ErrExists, p, link)
+		}
+		// renamed, also under ConflictOverwrite when another url of the batch has the name
+		p = filepath.Join(b.outdir, fmt.Sprintf("%s-%d%s", base, n, ext))
+	}
+	b.claims.paths[p] = index
+	return p, false, nil
+}
+
+// outputName returns the name relative to outdir the response of j with header is saved as,
+// before any renaming under the conflict policy
+func (b *batch) outputName(j Job, header http.Header) (string, error) {
+	switch {
+	case j.Output != "":
+		return j.Output, nil
+	case b.crawl != nil:
+		u, err := url.Parse(j.URL)
+		if err != nil {
+			return "", err
+		}
+		return treePath(u, header.Get("Content-Type")), nil
+	}
+	return filename(j.URL, header), nil
+}
+
+// extractTarget returns the path the download of the url at index is extracted to under the
+// conflict policy, dest or dest with a -N suffix, skip is set when the file there is kept. What
+// an earlier extraction left is replaced, other directories are never.
+func (b *batch) extractTarget(dest string, index int) (p string, skip bool, err error) {
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	b.claims.mu.Lock()
+	defer b.claims.mu.Unlock()
+	p = dest
+	for n := 1; ; n++ {
+		owner, claimed := b.claims.paths[p]
+		if claimed && owner == index {
+			return p, false, nil
+		}
+		if !claimed {
+			fi, err := os.Lstat(p)
+			if err != nil || extracted(p) || b.cfg.conflict == ConflictOverwrite && !fi.IsDir() {
+				break
+			}
+			switch b.cfg.conflict {
+			case ConflictSkip:
+				return p, true, nil
+			case ConflictError:
+				return "", false, fmt.Errorf("%w: %s", ErrExists, p)
+			}
+		}
+		p = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	b.claims.paths[p] = index
+	return p, false, nil
+}
+
+// skipped fills res for a url whose file at path is kept
+func skipped(p string, res *Result) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	res.Path = p
+	res.Bytes = fi.Size()
+	res.Skipped = true
+	return nil
+}
+
+// filename returns the name to save the response of link as: the name from its
+// Content-Disposition, else the last element of the url path, else the md5 of link with an
+// extension matching its Content-Type. Names never contain directories.
+func filename(link string, header http.Header) string {
+	if name := sanitize(dispositionName(header.Get("Content-Disposition"))); name != "" {
+		return name
+	}
+	if u, err := url.Parse(link); err == nil {
+		if name := sanitize(path.Base(u.Path)); name != "" && name != "/" {
+			return name
+		}
+	}
+	h := md5.New()
+	io.WriteString(h, link)
+	name := fmt.Sprintf("%x", h.Sum(nil))
+	ct := header.Get("Content-Type")
+	if ct != "" {
+		exts, err := mime.ExtensionsByType(ct)
+		if err == nil && len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	return name
+}
+
+// dispositionName returns the file name of a Content-Disposition header, preferring the
+// RFC 5987 filename* parameter
+func dispositionName(cd string) string {
+	if cd == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(cd)
+	if err == nil && params["filename"] != "" {
+		// mime decodes filename* in UTF-8 and uses it over filename
+		if name := extValue(cd, "filename*"); name != "" {
+			return name
+		}
+		return params["filename"]
+	}
+	return extValue(cd, "filename*")
+}
+
+// extValue decodes the RFC 5987 parameter key of header, charset'language'percent-encoded,
+// in the UTF-8 and ISO-8859-1 charsets every recipient must support
+func extValue(header, key string) string {
+	for _, param := range strings.Split(header, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), key) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(v), "'", 3)
+		if len(parts) != 3 {
+			return ""
+		}
+		raw, err := url.PathUnescape(parts[2])
+		if err != nil {
+			return ""
+		}
+		switch strings.ToLower(parts[0]) {
+		case "utf-8":
+			return raw
+		case "iso-8859-1":
+			runes := make([]rune, len(raw))
+			for i := 0; i < len(raw); i++ {
+				runes[i] = rune(raw[i])
+			}
+			return string(runes)
+		}
+		return ""
+	}
+	return ""
+}
+
+// reservedSuffixes end the names of the files gget keeps next to a download: its part file,
+// the state of that and the temporaries a file is replaced through
+var reservedSuffixes = []string{partExt, partExt + stateExt, ".tmp", ".gget-link"}
+
+// sanitize returns name without directories and control characters, empty when nothing
+// usable is left, so a server cannot make a file be written outside outdir. Hidden names and
+// names ending like gget's own files are renamed with a _, so a server cannot make a file
+// overwrite the mirror index, an extraction marker or the part file of another download.
+func sanitize(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return ""
+	}
+	if strings.HasPrefix(name, ".") {
+		name = "_" + name[1:]
+	}
+	for _, s := range reservedSuffixes {
+		if strings.HasSuffix(name, s) {
+			return name + "_"
+		}
+	}
+	return name
+}
@@ -0,0 +1,119 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNames(t *testing.T) {
+	t.Run("test file names", func(t *testing.T) {
+		for _, tc := range []struct {
+			link, cd, want string
+		}{
+			{"http://h/x", `attachment; filename="../../etc/passwd"`, "passwd"},
+			{"http://h/x", `attachment; filename="..\\..\\win.ini"`, "win.ini"},
+			{"http://h/x", `attachment; filename*=iso-8859-1''na%EFve.txt`, "naïve.txt"},
+			{"http://h/x", `attachment; filename="plain.txt"; filename*=UTF-8''na%C3%AFve.txt`, "naïve.txt"},
+			{"http://h/dir/file.tar.gz?v=1", `attachment; filename=".."`, "file.tar.gz"},
+			{"http://h/dir/", "", "dir"},
+			{"http://h/a%20b.txt", "", "a b.txt"},
+			{"http://h/", "", "a1b2"},
+			{"http://h/x", `attachment; filename=".gget-mirror.json"`, "_gget-mirror.json"},
+			{"http://h/.gget-extracted-app", "", "_gget-extracted-app"},
+			{"http://h/x", `attachment; filename="app.tar.gz.part.json"`, "app.tar.gz.part.json_"},
+			{"http://h/app.tar.gz.part", "", "app.tar.gz.part_"},
+		} {
+			got := filename(tc.link, http.Header{"Content-Disposition": {tc.cd}})
+			if tc.want == "a1b2" {
+				// nothing usable, the md5 of the url is used
+				if len(got) != 32 {
+					t.Errorf("%s: wanted an md5 name but got %q", tc.link, got)
+				}
+				continue
+			}
+			if got != tc.want {
+				t.Errorf("%s %s: wanted %q but got %q", tc.link, tc.cd, tc.want, got)
+			}
+		}
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(r.URL.Path)))
+	}))
+	defer srv.Close()
+	input := fmt.Sprintf("%s/a/f.bin\n%s/b/f.bin\n", srv.URL, srv.URL)
+	read := func(t *testing.T, path string) string {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	t.Run("test urls of a batch named alike", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := Get(context.Background(), strings.NewReader(input), 2, dir); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{read(t, filepath.Join(dir, "f.bin")), read(t, filepath.Join(dir, "f-1.bin"))}
+		if !(got[0] == "/a/f.bin" && got[1] == "/b/f.bin") && !(got[0] == "/b/f.bin" && got[1] == "/a/f.bin") {
+			t.Errorf("expected each url in its own file but got %q", got)
+		}
+	})
+
+	for _, tc := range []struct {
+		conflict Conflict
+		check    func(t *testing.T, dir string, rep *Report, err error)
+	}{
+		{ConflictOverwrite, func(t *testing.T, dir string, rep *Report, err error) {
+			if err != nil || read(t, filepath.Join(dir, "f.bin")) != "/a/f.bin" {
+				t.Errorf("expected the file to be overwritten, %v", err)
+			}
+		}},
+		{ConflictSkip, func(t *testing.T, dir string, rep *Report, err error) {
+			if err != nil || !rep.Results[0].Skipped || read(t, filepath.Join(dir, "f.bin")) != "old" {
+				t.Errorf("expected the file to be kept, %v", err)
+			}
+		}},
+		{ConflictRename, func(t *testing.T, dir string, rep *Report, err error) {
+			if err != nil || read(t, filepath.Join(dir, "f.bin")) != "old" || read(t, filepath.Join(dir, "f-1.bin")) != "/a/f.bin" {
+				t.Errorf("expected the url to be renamed, %v", err)
+			}
+		}},
+		{ConflictError, func(t *testing.T, dir string, rep *Report, err error) {
+			if !errors.Is(err, ErrExists) || len(rep.Results[0].Attempts) != 1 {
+				t.Errorf("expected ErrExists without retries but got %v", err)
+			}
+		}},
+	} {
+		tc := tc
+		t.Run("test conflict "+tc.conflict.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "f.bin"), []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			rep, err := Fetch(context.Background(), strings.NewReader(srv.URL+"/a/f.bin\n"), 1, dir, WithConflict(tc.conflict))
+			tc.check(t, dir, rep, err)
+		})
+	}
+
+	t.Run("test parse conflict", func(t *testing.T) {
+		for _, s := range []string{"overwrite", "skip", "rename", "error"} {
+			if c, err := ParseConflict(s); err != nil || c.String() != s {
+				t.Errorf("%s: got %v, %v", s, c, err)
+			}
+		}
+		if _, err := ParseConflict("clobber"); err == nil {
+			t.Errorf("expected an error for an unknown policy")
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package gget
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcLogin is the login for a machine of a netrc file
+type netrcLogin struct {
+	login, password string
+}
+
+// Netrc holds the logins of a netrc file by machine
+type Netrc struct {
+	machines map[string]netrcLogin
+	def      *netrcLogin
+}
+
+// ParseNetrc reads a netrc file, macros are skipped
+func ParseNetrc(r io.Reader) (*Netrc, error) {
+	n := &Netrc{machines: make(map[string]netrcLogin)}
+	var (
+		cur     *netrcLogin
+		machine string
+		isDef   bool
+	)
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		if isDef {
+			n.def = cur
+		} else if _, ok := n.machines[machine]; !ok {
+			// the first entry of a machine wins
+			n.machines[machine] = *cur
+		}
+		cur = nil
+	}
+	scanner := bufio.NewScanner(r)
+	var inMacro bool
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro ends at a blank line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			value := func() (string, error) {
+				if i+1 >= len(fields) {
+					return "", fmt.Errorf("netrc %s has no value", fields[i])
+				}
+				i++
+				return fields[i], nil
+			}
+			switch fields[i] {
+			case "machine":
+				flush()
+				m, err := value()
+				if err != nil {
+					return nil, err
+				}
+				machine, isDef, cur = strings.ToLower(m), false, &netrcLogin{}
+			case "default":
+				flush()
+				isDef, cur = true, &netrcLogin{}
+			case "login", "password", "account":
+				key := fields[i]
+				v, err := value()
+				if err != nil {
+					return nil, err
+				}
+				if cur == nil {
+					return nil, fmt.Errorf("netrc %s outside a machine", key)
+				}
+				switch key {
+				case "login":
+					cur.login = v
+				case "password":
+					cur.password = v
+				}
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			default:
+				return nil, fmt.Errorf("unknown netrc token %q", fields[i])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return n, nil
+}
+
+// LoadNetrc reads the netrc file at path, or at $NETRC or ~/.netrc when path is empty
+func LoadNetrc(path string) (*Netrc, error) {
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	n, err := ParseNetrc(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return n, nil
+}
+
+// login returns the login for host, without its port, or the default one
+func (n *Netrc) login(host string) (netrcLogin, bool) {
+	if n == nil {
+		return netrcLogin{}, false
+	}
+	if l, ok := n.machines[strings.ToLower(host)]; ok {
+		return l, true
+	}
+	if n.def != nil {
+		return *n.def, true
+	}
+	return netrcLogin{}, false
+}
@@ -0,0 +1,280 @@
+package gget
+
+import (
+	"net/http"
+	"time"
+)
+
+// config is what Options configure
+type config struct {
+	routines        int
+	retry           RetryPolicy
+	continueOnError bool
+	sums            map[string]*Checksum
+	quarantine      string
+	rate            int64
+	hostRate        int64
+	hostConns       int
+	hostDelay       time.Duration
+	conflict        Conflict
+	mirror          bool
+	crawl           *Crawl
+	input           InputFormat
+	mirrorOrder     MirrorOrder
+	mirrorSegments  bool
+	header          http.Header
+	userAgent       string
+	user, password  string
+	bearer          string
+	netrc           *Netrc
+	cookieFile      string
+	body            []byte
+	bodyType        string
+	transport       TransportConfig
+	extract         bool
+	removeArchive   bool
+	store           string
+	storeLink       LinkMode
+	localFiles      bool
+	progress        ProgressFunc
+	progressEvery   time.Duration
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		routines:  1,
+		retry:     DefaultRetryPolicy,
+		transport: DefaultTransportConfig,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Option configures a download
+type Option func(*config)
+
+// WithRoutines sets the number of routines a Downloader downloads with at a time, shared
+// between files and segments of large files, 1 otherwise
+func WithRoutines(n int) Option {
+	return func(c *config) {
+		if n < 1 {
+			n = 1
+		}
+		c.routines = n
+	}
+}
+
+// WithRetry sets the retry policy, DefaultRetryPolicy is used otherwise
+func WithRetry(p RetryPolicy) Option {
+	return func(c *config) {
+		c.retry = p
+	}
+}
+
+// WithContinueOnError keeps downloading the remaining urls after one fails
+func WithContinueOnError() Option {
+	return func(c *config) {
+		c.continueOnError = true
+	}
+}
+
+// WithChecksums verifies files against the checksums by file name, as parsed by ParseSums.
+// A checksum given with a url takes precedence.
+func WithChecksums(sums map[string]*Checksum) Option {
+	return func(c *config) {
+		if c.sums == nil {
+			c.sums = make(map[string]*Checksum)
+		}
+		for name, sum := range sums {
+			c.sums[name] = sum
+		}
+	}
+}
+
+// WithQuarantine moves files failing verification into dir instead of deleting them
+func WithQuarantine(dir string) Option {
+	return func(c *config) {
+		c.quarantine = dir
+	}
+}
+
+// WithRateLimit limits all downloads together to bytesPerSec, 0 means unlimited
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(c *config) {
+		c.rate = bytesPerSec
+	}
+}
+
+// WithHostRateLimit limits the downloads from each host to bytesPerSec, 0 means unlimited
+func WithHostRateLimit(bytesPerSec int64) Option {
+	return func(c *config) {
+		c.hostRate = bytesPerSec
+	}
+}
+
+// WithHostConnections caps the concurrent connections to each host at n, segments included,
+// 0 means only the routines limit applies. Routines move on to urls of other hosts meanwhile.
+func WithHostConnections(n int) Option {
+	return func(c *config) {
+		c.hostConns = n
+	}
+}
+
+// WithHostDelay spaces the requests to each host at least d apart
+func WithHostDelay(d time.Duration) Option {
+	return func(c *config) {
+		c.hostDelay = d
+	}
+}
+
+// WithProgress calls fn with a snapshot of the downloads every interval, 500ms when it is 0,
+// and once more with the final snapshot when they are over
+func WithProgress(fn ProgressFunc, every time.Duration) Option {
+	return func(c *config) {
+		c.progress = fn
+		c.progressEvery = every
+	}
+}
+
+// WithConflict sets what to do when the file a url is saved as exists, ConflictOverwrite
+// by default. Two urls of one Fetch saved under the same name are never written to one file.
+func WithConflict(policy Conflict) Option {
+	return func(c *config) {
+		c.conflict = policy
+	}
+}
+
+// WithMirror records the validators of every download in outdir and fetches a url again only
+// when the server reports it changed, setting file modification times from Last-Modified
+func WithMirror() Option {
+	return func(c *config) {
+		c.mirror = true
+	}
+}
+
+// WithCrawl downloads recursively, following the links of the pages downloaded per c
+func WithCrawl(c Crawl) Option {
+	return func(cfg *config) {
+		cfg.crawl = &c
+	}
+}
+
+// WithInputFormat sets how the input is written, InputAuto by default
+func WithInputFormat(f InputFormat) Option {
+	return func(c *config) {
+		c.input = f
+	}
+}
+
+// WithMirrorOrder sets the order the url and the mirrors of a job are tried in, MirrorsInOrder
+// by default
+func WithMirrorOrder(o MirrorOrder) Option {
+	return func(c *config) {
+		c.mirrorOrder = o
+	}
+}
+
+// WithMirrorSegments fetches the segments of large files from the url and the mirrors of a job
+// at the same time
+func WithMirrorSegments() Option {
+	return func(c *config) {
+		c.mirrorSegments = true
+	}
+}
+
+// WithHeader sends the headers with every request, the headers of a job take precedence
+func WithHeader(h http.Header) Option {
+	return func(c *config) {
+		if c.header == nil {
+			c.header = http.Header{}
+		}
+		for k, v := range h {
+			c.header[k] = append(c.header[k], v...)
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent of every request
+func WithUserAgent(ua string) Option {
+	return func(c *config) {
+		c.userAgent = ua
+	}
+}
+
+// WithBasicAuth sends the user and password with every request
+func WithBasicAuth(user, password string) Option {
+	return func(c *config) {
+		c.user, c.password = user, password
+	}
+}
+
+// WithBearerToken sends the token with every request, it takes precedence over basic auth
+func WithBearerToken(token string) Option {
+	return func(c *config) {
+		c.bearer = token
+	}
+}
+
+// WithNetrc sends the logins of n by host when no other credentials are given
+func WithNetrc(n *Netrc) Option {
+	return func(c *config) {
+		c.netrc = n
+	}
+}
+
+// WithCookieFile keeps cookies in the Netscape cookies.txt file at path, read before the
+// downloads and written back after them
+func WithCookieFile(path string) Option {
+	return func(c *config) {
+		c.cookieFile = path
+	}
+}
+
+// WithPostBody downloads the urls with a POST of body of the contentType instead of a GET,
+// such downloads are neither segmented nor resumed
+func WithPostBody(contentType string, body []byte) Option {
+	return func(c *config) {
+		c.bodyType, c.body = contentType, body
+	}
+}
+
+// WithTransport configures the connections, DefaultTransportConfig is used otherwise
+func WithTransport(t TransportConfig) Option {
+	return func(c *config) {
+		c.transport = t
+	}
+}
+
+// WithExtract extracts downloaded .tar.gz, .tgz, .tar.bz2, .tar and .zip archives into a
+// directory named after them and decompresses .gz and .bz2 files, removing the archives once
+// extracted if removeArchive is set. xz files are not supported, they fail and are kept. What
+// is in the way of an
+// extraction is dealt with by the conflict policy, except that what an earlier extraction left
+// is replaced and a directory is never overwritten but renamed around.
+func WithExtract(removeArchive bool) Option {
+	return func(c *config) {
+		c.extract = true
+		c.removeArchive = removeArchive
+	}
+}
+
+// WithStore keeps the downloads by their sha256 in the directory dir, as dir/sha256/ab/cdef...,
+// with the paths they are saved at linked to them per link. A url the store knows from an
+// earlier run, or whose sha256 checksum matches stored content, is linked without being
+// downloaded again, except in mirror mode.
+func WithStore(dir string, link LinkMode) Option {
+	return func(c *config) {
+		c.store = dir
+		c.storeLink = link
+	}
+}
+
+// WithLocalFiles lets file urls copy local files, they fail otherwise. The mirrors of a job
+// and the links found while crawling are never file urls, whatever the option.
+func WithLocalFiles() Option {
+	return func(c *config) {
+		c.localFiles = true
+	}
+}
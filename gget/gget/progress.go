@@ -0,0 +1,323 @@
+package gget
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultProgressEvery is how often progress is reported unless WithProgress says otherwise
+const defaultProgressEvery = 500 * time.Millisecond
+
+// FileProgress is the progress of one url being downloaded
+type FileProgress struct {
+	URL string
+	// Name is the file name the url is saved as, empty until it is known
+	Name string
+	// Bytes is how much of the file is on disk, including what an earlier run received
+	Bytes int64
+	// Size is the expected size of the file, -1 when unknown
+	Size int64
+	// Rate is the recent download speed in bytes per second
+	Rate float64
+	// ETA is the time left at Rate, -1 when unknown
+	ETA time.Duration
+}
+
+// Progress is a snapshot of the downloads of a Fetch
+type Progress struct {
+	Time time.Time
+	// Bytes is how much of the started files is on disk
+	Bytes int64
+	// Total is the expected size of the started files, -1 when the size of one is unknown
+	Total int64
+	// Rate is the recent download speed of all files together in bytes per second
+	Rate float64
+	// ETA is the time left for the started files at Rate, -1 when unknown
+	ETA    time.Duration
+	Active int
+	Done   int
+	Failed int
+	// Files are the urls being downloaded, in input order
+	Files []FileProgress
+	// Final is set on the last snapshot, sent once all downloads are over
+	Final bool
+}
+
+// ProgressFunc receives progress snapshots, it is called from one routine at a time
+type ProgressFunc func(Progress)
+
+type jsonFileProgress struct {
+	URL   string  `json:"url"`
+	Name  string  `json:"name,omitempty"`
+	Bytes int64   `json:"bytes"`
+	Size  int64   `json:"size"`
+	Rate  float64 `json:"rate"`
+	ETA   string  `json:"eta,omitempty"`
+}
+
+type jsonProgress struct {
+	Event  string             `json:"event"`
+	Time   time.Time          `json:"time"`
+	Bytes  int64              `json:"bytes"`
+	Total  int64              `json:"total"`
+	Rate   float64            `json:"rate"`
+	ETA    string             `json:"eta,omitempty"`
+	Active int                `json:"active"`
+	Done   int                `json:"done"`
+	Failed int                `json:"failed"`
+	Files  []jsonFileProgress `json:"files,omitempty"`
+}
+
+func etaString(d time.Duration) string {
+	if d < 0 {
+		return ""
+	}
+	return d.Round(time.Second).String()
+}
+
+// MarshalJSON encodes p as a "progress" event, or "finished" when it is final, with rates
+// in bytes per second and ETAs in their text form, omitted when unknown
+func (p Progress) MarshalJSON() ([]byte, error) {
+	jp := jsonProgress{
+		Event:  "progress",
+		Time:   p.Time,
+		Bytes:  p.Bytes,
+		Total:  p.Total,
+		Rate:   float64(int64(p.Rate)),
+		ETA:    etaString(p.ETA),
+		Active: p.Active,
+		Done:   p.Done,
+		Failed: p.Failed,
+	}
+	if p.Final {
+		jp.Event = "finished"
+	}
+	for _, f := range p.Files {
+		jp.Files = append(jp.Files, jsonFileProgress{
+			URL:   f.URL,
+			Name:  f.Name,
+			Bytes: f.Bytes,
+			Size:  f.Size,
+			Rate:  float64(int64(f.Rate)),
+			ETA:   etaString(f.ETA),
+		})
+	}
+	return json.Marshal(jp)
+}
+
+// eta returns the time to receive left bytes at rate, -1 when either is unknown
+func eta(left int64, rate float64) time.Duration {
+	if left < 0 || rate <= 0 {
+		return -1
+	}
+	return time.Duration(float64(left) / rate * float64(time.Second))
+}
+
+// smooth averages rate samples so the reported speed does not jump at every tick
+func smooth(prev, sample float64) float64 {
+	if prev == 0 {
+		return sample
+	}
+	return 0.7*prev + 0.3*sample
+}
+
+// transfer counts the bytes of one url, its methods do nothing on a nil transfer
+type transfer struct {
+	t     *tracker
+	index int
+	url   string
+	name  string
+
+	bytes atomic.Int64
+	size  atomic.Int64
+	moved atomic.Int64
+
+	// guarded by t.mu
+	lastMoved int64
+	rate      float64
+}
+
+// begin records that a download of path starts with have bytes of size on disk
+func (tr *transfer) begin(path string, have, size int64) {
+	if tr == nil {
+		return
+	}
+	tr.t.mu.Lock()
+	tr.name = filepath.Base(path)
+	tr.t.mu.Unlock()
+	tr.bytes.Store(have)
+	tr.size.Store(size)
+}
+
+// add records n bytes received
+func (tr *transfer) add(n int) {
+	if tr == nil {
+		return
+	}
+	tr.bytes.Add(int64(n))
+	tr.moved.Add(int64(n))
+	tr.t.moved.Add(int64(n))
+}
+
+// Write counts p as received, so a transfer can be written to alongside the file
+func (tr *transfer) Write(p []byte) (int, error) {
+	tr.add(len(p))
+	return len(p), nil
+}
+
+// tracker follows the transfers of a Fetch and reports snapshots of them to fn
+type tracker struct {
+	fn    ProgressFunc
+	every time.Duration
+	moved atomic.Int64
+
+	mu        sync.Mutex
+	active    map[int]*transfer
+	doneBytes int64
+	done      int
+	failed    int
+	last      time.Time
+	lastMoved int64
+	rate      float64
+}
+
+// newTracker returns nil when fn is nil, a nil tracker hands out nil transfers
+func newTracker(fn ProgressFunc, every time.Duration) *tracker {
+	if fn == nil {
+		return nil
+	}
+	if every <= 0 {
+		every = defaultProgressEvery
+	}
+	return &tracker{fn: fn, every: every, active: make(map[int]*transfer), last: time.Now()}
+}
+
+// start returns the transfer of j
+func (t *tracker) start(j job) *transfer {
+	if t == nil {
+		return nil
+	}
+	tr := &transfer{t: t, index: j.index, url: j.URL}
+	tr.size.Store(-1)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active[j.index] = tr
+	return tr
+}
+
+// finish records the outcome of tr
+func (t *tracker) finish(tr *transfer, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, tr.index)
+	t.doneBytes += tr.bytes.Load()
+	if err != nil {
+		t.failed++
+	} else {
+		t.done++
+	}
+}
+
+// drop forgets tr without recording an outcome, its job was paused
+func (t *tracker) drop(tr *transfer) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, tr.index)
+}
+
+// progress returns the progress of tr at the rate of the last snapshot, t.mu must be held
+func (tr *transfer) progress() FileProgress {
+	bytes, size := tr.bytes.Load(), tr.size.Load()
+	left := int64(-1)
+	if size >= 0 {
+		left = size - bytes
+	}
+	return FileProgress{
+		URL:   tr.url,
+		Name:  tr.name,
+		Bytes: bytes,
+		Size:  size,
+		Rate:  tr.rate,
+		ETA:   eta(left, tr.rate),
+	}
+}
+
+// run reports a snapshot every t.every until stop is closed, then the final one
+func (t *tracker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(t.every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.fn(t.snapshot(false))
+		case <-stop:
+			t.fn(t.snapshot(true))
+			return
+		}
+	}
+}
+
+func (t *tracker) snapshot(final bool) Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	dt := now.Sub(t.last).Seconds()
+	t.last = now
+	p := Progress{
+		Time:   now,
+		Bytes:  t.doneBytes,
+		Total:  t.doneBytes,
+		Active: len(t.active),
+		Done:   t.done,
+		Failed: t.failed,
+		Final:  final,
+	}
+	active := make([]*transfer, 0, len(t.active))
+	for _, tr := range t.active {
+		active = append(active, tr)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].index < active[j].index
+	})
+	for _, tr := range active {
+		moved := tr.moved.Load()
+		if dt > 0 {
+			tr.rate = smooth(tr.rate, float64(moved-tr.lastMoved)/dt)
+		}
+		tr.lastMoved = moved
+		f := tr.progress()
+		p.Bytes += f.Bytes
+		switch {
+		case f.Size < 0:
+			p.Total = -1
+		case p.Total >= 0:
+			p.Total += f.Size
+		}
+		p.Files = append(p.Files, f)
+	}
+	moved := t.moved.Load()
+	if dt > 0 {
+		t.rate = smooth(t.rate, float64(moved-t.lastMoved)/dt)
+	}
+	t.lastMoved = moved
+	p.Rate = t.rate
+	left := int64(-1)
+	if p.Total >= 0 {
+		left = p.Total - p.Bytes
+	}
+	p.ETA = eta(left, p.Rate)
+	if final {
+		p.ETA = 0
+	}
+	return p
+}
@@ -0,0 +1,71 @@
+package gget
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveFile(r.URL.Path[1:])(w, r)
+	}))
+	defer srv.Close()
+	input := fmt.Sprintf("%s/a.bin\n%s/b.bin\n", srv.URL, srv.URL)
+	size := int64(len(payload))
+
+	var snaps []Progress
+	record := func(p Progress) {
+		snaps = append(snaps, p)
+	}
+	// the two files take about half a second
+	err := Get(context.Background(), strings.NewReader(input), 2, t.TempDir(),
+		WithRateLimit(4*size), WithProgress(record, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) < 3 {
+		t.Fatalf("expected several snapshots but got %d", len(snaps))
+	}
+	last := snaps[len(snaps)-1]
+	if !last.Final || last.Done != 2 || last.Active != 0 || last.Bytes != 2*size || last.Total != 2*size {
+		t.Errorf("unexpected final snapshot %+v", last)
+	}
+	var moving bool
+	for i, p := range snaps[:len(snaps)-1] {
+		if p.Final {
+			t.Errorf("snapshot %d is final before the last", i)
+		}
+		if i > 0 && p.Bytes < snaps[i-1].Bytes {
+			t.Errorf("bytes went back from %d to %d", snaps[i-1].Bytes, p.Bytes)
+		}
+		for _, f := range p.Files {
+			if f.Name != "" && f.Size != size {
+				t.Errorf("wanted size %d for %s but got %d", size, f.Name, f.Size)
+			}
+			if f.Rate > 0 && f.ETA >= 0 {
+				moving = true
+			}
+		}
+	}
+	if !moving {
+		t.Errorf("expected a file with a rate and an eta")
+	}
+
+	b, err := json.Marshal(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev map[string]interface{}
+	if err := json.Unmarshal(b, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev["event"] != "finished" || ev["done"] != float64(2) {
+		t.Errorf("unexpected event %s", b)
+	}
+}
@@ -0,0 +1,179 @@
+package gget
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket of bytes. Waiters reserve tokens ahead, driving the bucket into
+// debt, so concurrent readers are served in turn at the configured rate.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a bucket refilling at rate bytes per second, holding at most a tenth of a
+// second worth of tokens so reads are spread out instead of bursting
+func newBucket(rate int64) *bucket {
+	burst := float64(rate) / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens, blocking until the bucket has refilled enough for them
+func (b *bucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimiter limits the bytes read by all downloads together and per host
+type rateLimiter struct {
+	global  *bucket
+	perHost int64
+
+	mu    sync.Mutex
+	hosts map[string]*bucket
+}
+
+func newRateLimiter(global, perHost int64) *rateLimiter {
+	if global <= 0 && perHost <= 0 {
+		return nil
+	}
+	l := &rateLimiter{perHost: perHost, hosts: make(map[string]*bucket)}
+	if global > 0 {
+		l.global = newBucket(global)
+	}
+	return l
+}
+
+// buckets returns the buckets a read from link has to take tokens from
+func (l *rateLimiter) buckets(link string) []*bucket {
+	var bs []*bucket
+	if l.global != nil {
+		bs = append(bs, l.global)
+	}
+	if l.perHost > 0 {
+		host := link
+		if u, err := url.Parse(link); err == nil {
+			host = u.Host
+		}
+		l.mu.Lock()
+		b, ok := l.hosts[host]
+		if !ok {
+			b = newBucket(l.perHost)
+			l.hosts[host] = b
+		}
+		l.mu.Unlock()
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+// reader returns r limited to the rates applying to link, l may be nil
+func (l *rateLimiter) reader(ctx context.Context, link string, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	bs := l.buckets(link)
+	chunk := 32 << 10
+	for _, b := range bs {
+		if int(b.burst) < chunk {
+			chunk = int(b.burst)
+		}
+	}
+	return &limitedReader{ctx: ctx, r: r, buckets: bs, chunk: chunk}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*bucket
+	chunk   int
+}
+
+// Read reads at most one chunk and then waits for the tokens of what it read
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.chunk {
+		p = p[:lr.chunk]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		for _, b := range lr.buckets {
+			if werr := b.wait(lr.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+// ParseRate parses a rate in bytes per second, with an optional k, m or g suffix for
+// multiples of 1024 as in "500k" or "2M"
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	in := s
+	mult := int64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		mult = 1 << 10
+	case "m":
+		mult = 1 << 20
+	case "g":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(v >= 0) || v*float64(mult) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	rate := int64(v * float64(mult))
+	if rate == 0 && v > 0 {
+		// 0 means unlimited, a rate below a byte per second must not turn into that
+		return 0, fmt.Errorf("rate %q is below 1 byte per second", in)
+	}
+	return rate, nil
+}
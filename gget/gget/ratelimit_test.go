@@ -0,0 +1,71 @@
+package gget
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	newServer := func(prefix string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveFile(prefix+r.URL.Path[1:])(w, r)
+		}))
+	}
+	input := func(srvs ...*httptest.Server) string {
+		var b strings.Builder
+		for _, srv := range srvs {
+			for i := 0; i < 2; i++ {
+				fmt.Fprintf(&b, "%s/f%d.bin\n", srv.URL, i)
+			}
+		}
+		return b.String()
+	}
+	size := float64(len(payload))
+
+	t.Run("test global rate shared by routines", func(t *testing.T) {
+		srv := newServer("a")
+		defer srv.Close()
+		rate := int64(2 * size) // the two files take a second together
+		start := time.Now()
+		if err := Get(context.Background(), strings.NewReader(input(srv)), 4, t.TempDir(), WithRateLimit(rate)); err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start).Seconds()
+		if got := 2 * size / elapsed; got > float64(rate)*1.2 || got < float64(rate)*0.6 {
+			t.Errorf("wanted about %d bytes/s but got %.0f in %.2fs", rate, got, elapsed)
+		}
+	})
+
+	t.Run("test rate per host", func(t *testing.T) {
+		a, b := newServer("a"), newServer("b")
+		defer a.Close()
+		defer b.Close()
+		rate := int64(2 * size) // each host's two files take a second, in parallel
+		start := time.Now()
+		if err := Get(context.Background(), strings.NewReader(input(a, b)), 4, t.TempDir(), WithHostRateLimit(rate)); err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start).Seconds()
+		if got := 4 * size / elapsed; got > 2*float64(rate)*1.2 || got < 2*float64(rate)*0.6 {
+			t.Errorf("wanted about %d bytes/s but got %.0f in %.2fs", 2*rate, got, elapsed)
+		}
+	})
+
+	t.Run("test parse rate", func(t *testing.T) {
+		for in, want := range map[string]int64{"": 0, "0": 0, "100": 100, "2k": 2048, "1.5M": 3 << 19, "1g": 1 << 30} {
+			if got, err := ParseRate(in); err != nil || got != want {
+				t.Errorf("%q: wanted %d but got %d, %v", in, want, got, err)
+			}
+		}
+		for _, in := range []string{"fast", "0.5", "0.0001k", "-1", "NaN", "Inf", "1e30g"} {
+			if _, err := ParseRate(in); err == nil {
+				t.Errorf("%q: expected an error for an invalid rate", in)
+			}
+		}
+	})
+}
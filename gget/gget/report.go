@@ -0,0 +1,164 @@
+package gget
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+	"time"
+)
+
+// Result is the outcome of downloading one url
+type Result struct {
+	URL        string
+	StatusCode int
+	// Bytes is the size of the downloaded file, or what was received of it on failure
+	Bytes    int64
+	Duration time.Duration
+	// Path is where the file was saved, empty on failure
+	Path string
+	// Source is the url of URL and its mirrors the file was downloaded from, or tried last
+	Source string
+	// Skipped is set when the file at Path existed and was kept, per the conflict policy or
+	// because it was not modified in mirror mode
+	Skipped bool
+	// Extracted is the directory the archive at Path was extracted to, or the file it was
+	// decompressed to, with extraction on
+	Extracted string
+	// Digest is the hex sha256 the content was stored by, with a store
+	Digest   string
+	Err      error
+	Attempts []Attempt
+
+	// index is the position of URL in the input
+	index int
+	// origin is the url given that led to URL, the input url itself or the one crawled from,
+	// the configured credentials are sent to its host only
+	origin string
+	// host is the host whose connection the download holds while held is set, it is given back
+	// once the download is over
+	host string
+	held bool
+	// remote is the name the server gives the file, whatever name it is saved under
+	remote string
+	// crawled is set for links found while crawling
+	crawled bool
+	// canceled is set for a job canceled by its handle
+	canceled bool
+	// tr counts the bytes received while downloading
+	tr *transfer
+}
+
+// OK reports whether the url was downloaded
+func (r Result) OK() bool {
+	return r.Err == nil
+}
+
+type jsonAttempt struct {
+	Start    time.Time `json:"start"`
+	Duration string    `json:"duration"`
+	Delay    string    `json:"delay,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type jsonResult struct {
+	URL        string        `json:"url"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Bytes      int64         `json:"bytes"`
+	Duration   string        `json:"duration"`
+	Path       string        `json:"path,omitempty"`
+	Source     string        `json:"source,omitempty"`
+	Skipped    bool          `json:"skipped,omitempty"`
+	Extracted  string        `json:"extracted,omitempty"`
+	Digest     string        `json:"digest,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Attempts   []jsonAttempt `json:"attempts,omitempty"`
+}
+
+// MarshalJSON encodes errors as strings and durations in their text form
+func (r Result) MarshalJSON() ([]byte, error) {
+	jr := jsonResult{
+		URL:        r.URL,
+		StatusCode: r.StatusCode,
+		Bytes:      r.Bytes,
+		Duration:   r.Duration.String(),
+		Path:       r.Path,
+		Source:     r.Source,
+		Skipped:    r.Skipped,
+		Extracted:  r.Extracted,
+		Digest:     r.Digest,
+	}
+	if r.Err != nil {
+		jr.Error = r.Err.Error()
+	}
+	for _, a := range r.Attempts {
+		ja := jsonAttempt{Start: a.Start, Duration: a.Duration.String()}
+		if a.Delay > 0 {
+			ja.Delay = a.Delay.String()
+		}
+		if a.Err != nil {
+			ja.Error = a.Err.Error()
+		}
+		jr.Attempts = append(jr.Attempts, ja)
+	}
+	return json.Marshal(jr)
+}
+
+// Report holds the results of a batch in input order
+type Report struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Results  []Result  `json:"results"`
+}
+
+// Failed returns the number of urls that could not be downloaded
+func (r *Report) Failed() int {
+	var n int
+	for _, res := range r.Results {
+		if !res.OK() {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteTable writes a summary of the results as an aligned table
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tSTATUS\tBYTES\tDURATION\tATTEMPTS\tRESULT")
+	for _, res := range r.Results {
+		status := "-"
+		if res.StatusCode != 0 {
+			status = fmt.Sprint(res.StatusCode)
+		}
+		outcome := res.Path
+		if res.Source != "" && res.Source != res.URL {
+			outcome += " from " + res.Source
+		}
+		switch {
+		case res.Skipped && res.StatusCode == http.StatusNotModified:
+			outcome += " (not modified)"
+		case res.Skipped && res.Digest != "":
+			outcome += " (in store)"
+		case res.Skipped:
+			outcome += " (exists, skipped)"
+		}
+		if res.Extracted != "" {
+			outcome += " extracted to " + res.Extracted
+		}
+		if res.Err != nil {
+			outcome = "error: " + res.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%s\n", res.URL, status, res.Bytes, res.Duration.Round(time.Millisecond), len(res.Attempts), outcome)
+	}
+	fmt.Fprintf(tw, "\n%d downloaded, %d failed in %s\n", len(r.Results)-r.Failed(), r.Failed(), r.Finished.Sub(r.Started).Round(time.Millisecond))
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,72 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// prepare adds the extra headers of the batch, the user agent and the netrc login of its host to
+// req, what is set already wins
+func (b *batch) prepare(req *http.Request) {
+	addMissing(req.Header, b.cfg.header)
+	if b.cfg.userAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", b.cfg.userAgent)
+	}
+	if req.Header.Get("Authorization") != "" {
+		return
+	}
+	if l, ok := b.cfg.netrc.login(req.URL.Hostname()); ok && l.login != "" {
+		req.SetBasicAuth(l.login, l.password)
+	}
+}
+
+// jobHeader returns h with what else a request of j to link carries: the extra headers of j it
+// does not set itself and, when link is on the host of origin, the url given that led to j, the
+// configured credentials. Mirrors and crawled links on other hosts only get netrc logins.
+func (b *batch) jobHeader(j Job, origin, link string, h http.Header) http.Header {
+	out := h.Clone()
+	if out == nil {
+		out = http.Header{}
+	}
+	addMissing(out, j.Header)
+	if out.Get("Authorization") != "" || !strings.EqualFold(hostOf(link), hostOf(origin)) {
+		return out
+	}
+	switch {
+	case b.cfg.bearer != "":
+		out.Set("Authorization", "Bearer "+b.cfg.bearer)
+	case b.cfg.user != "":
+		out.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(b.cfg.user+":"+b.cfg.password)))
+	}
+	return out
+}
+
+func addMissing(dst, src http.Header) {
+	for k, v := range src {
+		if _, set := dst[k]; !set {
+			dst[k] = v
+		}
+	}
+}
+
+// open sends the request starting the download of link with the extra header, the
+// configured POST or else a GET
+func (b *batch) open(ctx context.Context, link string, header http.Header) (*http.Response, error) {
+	if b.cfg.body == nil {
+		return b.send(ctx, link, header)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, link, bytes.NewReader(b.cfg.body))
+	if err != nil {
+		return nil, err
+	}
+	if b.cfg.bodyType != "" {
+		req.Header.Set("Content-Type", b.cfg.bodyType)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return b.do(req)
+}
@@ -0,0 +1,187 @@
+package gget
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRequest(t *testing.T) {
+	// server serves payload to the requests ok accepts and counts the requests by method
+	type server struct {
+		*httptest.Server
+		mu      sync.Mutex
+		methods map[string]int
+	}
+	newServer := func(ok func(r *http.Request) bool) *server {
+		s := &server{methods: map[string]int{}}
+		s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.mu.Lock()
+			s.methods[r.Method]++
+			s.mu.Unlock()
+			if !ok(r) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			serveFile("f.bin")(w, r)
+		}))
+		return s
+	}
+	get := func(t *testing.T, in string, opts ...Option) {
+		t.Helper()
+		opts = append(opts, WithRetry(RetryPolicy{MaxAttempts: 1}))
+		if err := Get(context.Background(), strings.NewReader(in), 1, t.TempDir(), opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("test headers user agent and basic auth", func(t *testing.T) {
+		srv := newServer(func(r *http.Request) bool {
+			user, pass, _ := r.BasicAuth()
+			return r.Header.Get("X-Team") == "job" && r.Header.Get("X-Batch") == "1" &&
+				r.UserAgent() == "gget-test" && user == "ann" && pass == "pw"
+		})
+		defer srv.Close()
+		in := `{"url": "` + srv.URL + `/f", "headers": {"X-Team": "job"}}`
+		get(t, in, WithHeader(http.Header{"X-Team": {"batch"}, "X-Batch": {"1"}}), WithUserAgent("gget-test"), WithBasicAuth("ann", "pw"))
+	})
+
+	t.Run("test bearer token", func(t *testing.T) {
+		srv := newServer(func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer tok"
+		})
+		defer srv.Close()
+		get(t, srv.URL+"/f\n", WithBasicAuth("ann", "pw"), WithBearerToken("tok"))
+	})
+
+	t.Run("test credentials stay on the host given", func(t *testing.T) {
+		primary := httptest.NewServer(http.NotFoundHandler())
+		defer primary.Close()
+		var (
+			mu   sync.Mutex
+			auth []string
+		)
+		mirror := newServer(func(r *http.Request) bool {
+			mu.Lock()
+			auth = append(auth, r.Header.Get("Authorization"))
+			mu.Unlock()
+			return true
+		})
+		defer mirror.Close()
+		in := `{"url": "` + primary.URL + `/f", "mirrors": ["` + mirror.URL + `/f"]}`
+		for _, opt := range []Option{WithBearerToken("secret"), WithBasicAuth("ann", "pw")} {
+			auth = nil
+			get(t, in, opt)
+			if len(auth) == 0 {
+				t.Fatal("expected the mirror to be asked")
+			}
+			for _, a := range auth {
+				if a != "" {
+					t.Errorf("expected the mirror on another host to get no credentials but got %q", a)
+				}
+			}
+		}
+	})
+
+	t.Run("test netrc", func(t *testing.T) {
+		srv := newServer(func(r *http.Request) bool {
+			user, pass, _ := r.BasicAuth()
+			return user == "bob" && pass == "secret"
+		})
+		defer srv.Close()
+		n, err := ParseNetrc(strings.NewReader("machine other.example login x password y\n" +
+			"macdef init\ncd /pub\n\n" +
+			"machine 127.0.0.1\n  login bob\n  password secret\ndefault login anonymous password guest\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		get(t, srv.URL+"/f\n", WithNetrc(n))
+		if l, _ := n.login("elsewhere"); l.login != "anonymous" {
+			t.Errorf("expected the default login but got %q", l.login)
+		}
+	})
+
+	t.Run("test cookie file", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+				http.Error(w, "no session", http.StatusUnauthorized)
+				return
+			}
+			if _, err := r.Cookie("stale"); err == nil {
+				http.Error(w, "expired cookie sent", http.StatusBadRequest)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "seen", Value: "1", Path: "/", MaxAge: 3600, HttpOnly: true})
+			serveFile("f.bin")(w, r)
+		}))
+		defer srv.Close()
+		file := filepath.Join(t.TempDir(), "cookies.txt")
+		jar := "# Netscape HTTP Cookie File\n" +
+			"127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\tabc\n" +
+			"127.0.0.1\tFALSE\t/\tFALSE\t1\tstale\tx\n" +
+			".other.example\tTRUE\t/\tTRUE\t4102444800\tkeep\tme\n"
+		if err := os.WriteFile(file, []byte(jar), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		get(t, srv.URL+"/f\n", WithCookieFile(file))
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\tabc", "#HttpOnly_127.0.0.1\tFALSE\t/\tFALSE\t", "\tseen\t1", ".other.example\tTRUE\t/\tTRUE\t4102444800\tkeep\tme"} {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("expected the saved cookies to contain %q:\n%s", want, b)
+			}
+		}
+		if strings.Contains(string(b), "stale") {
+			t.Errorf("expected expired cookies to be dropped:\n%s", b)
+		}
+	})
+
+	t.Run("test cookie domains", func(t *testing.T) {
+		for _, tc := range []struct {
+			host, domain string
+			// want is the domain the cookie is stored for, empty when it is rejected
+			want     string
+			hostOnly bool
+		}{
+			{"www.example.com", "example.com", "example.com", false},
+			{"www.example.com", ".www.example.com", "www.example.com", false},
+			{"www.example.com", "com", "", false},
+			{"www.example.co.uk", "co.uk", "", false},
+			{"www.example.co.uk", "example.co.uk", "example.co.uk", false},
+			{"www.example.com", "other.com", "", false},
+			{"localhost", "localhost", "localhost", true},
+			{"10.0.0.1", "0.0.1", "", false},
+			{"10.0.0.1", "10.0.0.1", "10.0.0.1", true},
+		} {
+			j := &cookieJar{}
+			j.SetCookies(&url.URL{Scheme: "http", Host: tc.host, Path: "/"}, []*http.Cookie{{Name: "n", Value: "v", Domain: tc.domain}})
+			switch {
+			case tc.want == "" && len(j.cookies) != 0:
+				t.Errorf("%s setting a cookie for %s should be rejected but got %+v", tc.host, tc.domain, j.cookies[0])
+			case tc.want != "" && (len(j.cookies) != 1 || j.cookies[0].domain != tc.want || j.cookies[0].hostOnly != tc.hostOnly):
+				t.Errorf("%s setting a cookie for %s should store it for %s, host only %v, but got %+v", tc.host, tc.domain, tc.want, tc.hostOnly, j.cookies)
+			}
+		}
+	})
+
+	t.Run("test post body", func(t *testing.T) {
+		srv := newServer(func(r *http.Request) bool {
+			body, _ := io.ReadAll(r.Body)
+			return r.Method == http.MethodPost && string(body) == `{"q":1}` && r.Header.Get("Content-Type") == "application/json"
+		})
+		defer srv.Close()
+		get(t, srv.URL+"/f\n", WithPostBody("application/json", []byte(`{"q":1}`)))
+		if srv.methods[http.MethodHead] != 0 || srv.methods[http.MethodGet] != 0 {
+			t.Errorf("expected only POST requests but got %v", srv.methods)
+		}
+	})
+}
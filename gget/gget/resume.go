@@ -0,0 +1,140 @@
+package gget
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	partExt  = ".part"
+	stateExt = ".json"
+
+	// checkpointEvery is how many bytes are received between saves of the part state
+	checkpointEvery = 4 << 20
+)
+
+// partState is the sidecar of a .part file, recording what is needed to resume it
+type partState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size"`
+	Received     int64  `json:"received"`
+	// Segments are the ranges left of a segmented download
+	Segments []*segment `json:"segments,omitempty"`
+}
+
+func loadPartState(part string) (*partState, error) {
+	b, err := os.ReadFile(part + stateExt)
+	if err != nil {
+		return nil, err
+	}
+	var st partState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("part state %s is corrupt: %v", part+stateExt, err)
+	}
+	return &st, nil
+}
+
+func (st *partState) save(part string) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := part + stateExt + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, part+stateExt)
+}
+
+func removePartState(part string) error {
+	err := os.Remove(part + stateExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// validator returns the value to send in If-Range, weak etags can not be used there
+func (st *partState) validator() string {
+	if st.ETag != "" && !strings.HasPrefix(st.ETag, "W/") {
+		return st.ETag
+	}
+	return st.LastModified
+}
+
+// matches reports whether resp serves the same content the part was downloaded from
+func (st *partState) matches(link string, resp *http.Response) bool {
+	if st.URL != link || st.validator() == "" {
+		return false
+	}
+	if st.ETag != "" {
+		return resp.Header.Get("ETag") == st.ETag
+	}
+	return resp.Header.Get("Last-Modified") == st.LastModified
+}
+
+// contentRange parses a "bytes first-last/size" Content-Range header, size is -1 when unknown
+func contentRange(v string) (first, size int64, err error) {
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	rng, total, ok := strings.Cut(strings.TrimPrefix(v, "bytes "), "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	firstStr, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	first, err = strconv.ParseInt(firstStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	if total == "*" {
+		return first, -1, nil
+	}
+	size, err = strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	return first, size, nil
+}
+
+// checkpointWriter writes to a part file, saving its state every checkpointEvery bytes
+type checkpointWriter struct {
+	f     *os.File
+	part  string
+	st    *partState
+	since int64
+}
+
+func (w *checkpointWriter) Write(b []byte) (int, error) {
+	n, err := w.f.Write(b)
+	w.st.Received += int64(n)
+	w.since += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if w.since >= checkpointEvery {
+		w.since = 0
+		if err := w.checkpoint(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// checkpoint makes the received bytes durable before recording them
+func (w *checkpointWriter) checkpoint() error {
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("file sync %s failed with %v", w.part, err)
+	}
+	return w.st.save(w.part)
+}
@@ -0,0 +1,123 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	t.Run("test interrupted download resumes", func(t *testing.T) {
+		interrupt := true
+		var ranges []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				ranges = append(ranges, r.Header.Get("Range"))
+			}
+			if interrupt {
+				w.Header().Set("Content-Disposition", `attachment; filename="data.bin"`)
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+				w.Write(payload[:len(payload)/2])
+				panic(http.ErrAbortHandler)
+			}
+			serveFile("data.bin")(w, r)
+		}))
+		defer srv.Close()
+		outdir := t.TempDir()
+
+		noRetry := WithRetry(RetryPolicy{MaxAttempts: 1})
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, outdir, noRetry); err == nil {
+			t.Fatal("expected the interrupted download to fail")
+		}
+		if _, err := os.Stat(filepath.Join(outdir, "data.bin")); err == nil {
+			t.Fatal("incomplete download should not be renamed into place")
+		}
+		st, err := loadPartState(filepath.Join(outdir, "data.bin.part"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Received != int64(len(payload)/2) || st.ETag != `"v1"` {
+			t.Errorf("unexpected part state %+v", st)
+		}
+
+		interrupt = false
+		ranges = nil
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, outdir); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(outdir, "data.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("resumed file differs from payload")
+		}
+		if want := "bytes=" + strconv.Itoa(len(payload)/2) + "-"; len(ranges) != 1 || ranges[0] != want {
+			t.Errorf("wanted only a request of range %s but got %q", want, ranges)
+		}
+		for _, name := range []string{"data.bin.part", "data.bin.part.json"} {
+			if _, err := os.Stat(filepath.Join(outdir, name)); err == nil {
+				t.Errorf("%s should be removed after completion", name)
+			}
+		}
+	})
+
+	t.Run("test server ignoring range", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Disposition", `attachment; filename="data.bin"`)
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(payload)
+		}))
+		defer srv.Close()
+		outdir := t.TempDir()
+		part := filepath.Join(outdir, "data.bin.part")
+		if err := os.WriteFile(part, []byte("garbage"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		st := &partState{URL: srv.URL, ETag: `"v1"`, Size: int64(len(payload)), Received: 7}
+		if err := st.save(part); err != nil {
+			t.Fatal(err)
+		}
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, outdir); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(outdir, "data.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("file differs from payload")
+		}
+	})
+
+	t.Run("test changed content restarts", func(t *testing.T) {
+		srv := httptest.NewServer(serveFile("data.bin"))
+		defer srv.Close()
+		outdir := t.TempDir()
+		part := filepath.Join(outdir, "data.bin.part")
+		if err := os.WriteFile(part, []byte("garbage"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		st := &partState{URL: srv.URL, ETag: `"v0"`, Size: int64(len(payload)), Received: 7}
+		if err := st.save(part); err != nil {
+			t.Fatal(err)
+		}
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, outdir); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(outdir, "data.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("file differs from payload")
+		}
+	})
+}
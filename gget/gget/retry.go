@@ -0,0 +1,199 @@
+package gget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides how often and when a failed download is tried again
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries of a url, 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure, it doubles with every further failure
+	BaseDelay time.Duration
+	// MaxDelay caps the wait, including one asked for with Retry-After
+	MaxDelay time.Duration
+	// Jitter is the fraction, between 0 and 1, of each wait that is randomized
+	Jitter float64
+}
+
+// DefaultRetryPolicy tries a url 3 times, waiting about 1s and 2s in between
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.5,
+}
+
+// Attempt is one try at downloading a url
+type Attempt struct {
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+	// Delay is the wait before the next attempt, 0 for the last one
+	Delay time.Duration
+}
+
+// DownloadError is returned for a url that could not be downloaded, with all its attempts
+type DownloadError struct {
+	URL      string
+	Attempts []Attempt
+}
+
+func (e *DownloadError) Error() string {
+	last := e.Unwrap()
+	if len(e.Attempts) == 1 {
+		return last.Error()
+	}
+	return fmt.Sprintf("%s failed after %d attempts: %v", e.URL, len(e.Attempts), last)
+}
+
+// Unwrap returns the error of the last attempt
+func (e *DownloadError) Unwrap() error {
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// StatusError is returned when a server answers with an unexpected status
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	// RetryAfter is the wait the server asked for, if any
+	RetryAfter time.Duration
+}
+
+func newStatusError(link string, resp *http.Response) *StatusError {
+	return &StatusError{
+		URL:        link,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("get %s failed with status %s", e.URL, e.Status)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Retryable reports whether err is a transient failure worth another attempt: timeouts, refused
+// or reset connections, truncated bodies, checksum mismatches, 408, 425, 429 and 5xx responses.
+// Other errors, such as 4xx responses, bad urls or local file errors, are permanent.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var ce *ChecksumError
+	if errors.As(err, &ce) {
+		return true
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		switch {
+		case se.StatusCode == http.StatusRequestTimeout,
+			se.StatusCode == http.StatusTooEarly,
+			se.StatusCode == http.StatusTooManyRequests:
+			return true
+		case se.StatusCode == http.StatusNotImplemented,
+			se.StatusCode == http.StatusHTTPVersionNotSupported:
+			return false
+		}
+		return se.StatusCode >= 500
+	}
+	if errors.Is(err, errIncomplete) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		// the transport reports dropped connections as plain errors
+		msg := ue.Err.Error()
+		return strings.Contains(msg, "connection reset") || strings.Contains(msg, "server closed")
+	}
+	return false
+}
+
+// delay returns the wait after the given failed attempt, counting from 1
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	d := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(attempt-1)))
+	if p.Jitter > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > d {
+		d = se.RetryAfter
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// do calls fn until it succeeds, fails permanently or the attempts run out
+func (p RetryPolicy) do(ctx context.Context, link string, fn func() error) error {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	var attempts []Attempt
+	for i := 1; ; i++ {
+		a := Attempt{Start: time.Now()}
+		err := fn()
+		a.Duration = time.Since(a.Start)
+		if err == nil {
+			return nil
+		}
+		a.Err = err
+		if i >= maxAttempts || ctx.Err() != nil || !Retryable(err) {
+			attempts = append(attempts, a)
+			return &DownloadError{URL: link, Attempts: attempts}
+		}
+		a.Delay = p.delay(i, err)
+		attempts = append(attempts, a)
+
+		t := time.NewTimer(a.Delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			attempts[len(attempts)-1].Delay = 0
+			return &DownloadError{URL: link, Attempts: attempts}
+		}
+	}
+}
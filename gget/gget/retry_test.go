@@ -0,0 +1,85 @@
+package gget
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	t.Run("test transient failures are retried", func(t *testing.T) {
+		var calls int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				return
+			}
+			calls++
+			switch calls {
+			case 1:
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case 2:
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+			default:
+				serveFile("data.bin")(w, r)
+			}
+		}))
+		defer srv.Close()
+		start := time.Now()
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, t.TempDir(), WithRetry(policy)); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 3 {
+			t.Errorf("wanted 3 calls but got %d", calls)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("retry after should be capped by the max delay, took %v", elapsed)
+		}
+	})
+
+	t.Run("test permanent failures are not retried", func(t *testing.T) {
+		var calls int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				calls++
+			}
+			http.NotFound(w, r)
+		}))
+		defer srv.Close()
+		err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, t.TempDir(), WithRetry(policy))
+		var de *DownloadError
+		if !errors.As(err, &de) {
+			t.Fatalf("wanted a download error but got %v", err)
+		}
+		var se *StatusError
+		if len(de.Attempts) != 1 || !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+			t.Errorf("wanted a single 404 attempt but got %v", de.Attempts)
+		}
+		if calls != 1 {
+			t.Errorf("wanted 1 call but got %d", calls)
+		}
+	})
+
+	t.Run("test attempts run out", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer srv.Close()
+		err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 1, t.TempDir(), WithRetry(policy))
+		var de *DownloadError
+		if !errors.As(err, &de) || len(de.Attempts) != 3 {
+			t.Fatalf("wanted 3 attempts but got %v", err)
+		}
+		for i, a := range de.Attempts[:2] {
+			if a.Delay <= 0 || a.Delay > policy.MaxDelay {
+				t.Errorf("attempt %d: unexpected delay %v", i, a.Delay)
+			}
+		}
+	})
+}
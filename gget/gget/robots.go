@@ -0,0 +1,197 @@
+package gget
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// robotsAgent is the user agent gget looks for in robots.txt
+const robotsAgent = "gget"
+
+// robotsRule allows or disallows the paths matching pattern, in which * matches any
+// sequence and a trailing $ anchors the end
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robots are the rules of a robots.txt that apply to gget, nil allows everything
+type robots struct {
+	rules []robotsRule
+}
+
+// disallowAll are the rules of a host whose robots.txt is unreachable or fails with a 5xx
+var disallowAll = &robots{rules: []robotsRule{{allow: false, pattern: "/"}}}
+
+// productToken returns the product token of a user-agent value, e.g. gget of gget/1.0, which
+// RFC 9309 has crawlers match their own against
+func productToken(agent string) string {
+	token, _, _ := strings.Cut(agent, "/")
+	return strings.TrimSpace(token)
+}
+
+// parseRobots reads the group of r naming gget, or else the group for *
+func parseRobots(r io.Reader) (*robots, error) {
+	var (
+		own, all []robotsRule
+		hasOwn   bool
+		agents   []string
+		inRules  bool
+		addToOwn bool
+		addToAny bool
+		scanner  = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		switch k {
+		case "user-agent":
+			if inRules {
+				// a user-agent after rules starts a new group
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(v))
+			addToOwn, addToAny = false, false
+			for _, a := range agents {
+				if a == "*" {
+					addToAny = true
+				} else if productToken(a) == robotsAgent {
+					addToOwn, hasOwn = true, true
+				}
+			}
+		case "allow", "disallow":
+			inRules = true
+			if v == "" {
+				// an empty disallow allows everything
+				continue
+			}
+			rule := robotsRule{allow: k == "allow", pattern: v}
+			if addToOwn {
+				own = append(own, rule)
+			}
+			if addToAny {
+				all = append(all, rule)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if hasOwn {
+		return &robots{rules: own}, nil
+	}
+	return &robots{rules: all}, nil
+}
+
+// allowed reports whether the path and query of u may be fetched, the longest matching rule
+// decides and allow wins a tie
+func (r *robots) allowed(u *url.URL) bool {
+	if r == nil {
+		return true
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	best, allow := -1, true
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, p) {
+			continue
+		}
+		if n := len(rule.pattern); n > best || (n == best && rule.allow) {
+			best, allow = n, rule.allow
+		}
+	}
+	return allow
+}
+
+// robotsMatch reports whether pattern matches the start of p
+func robotsMatch(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "$") {
+		return wildcard(strings.TrimSuffix(pattern, "$"), p)
+	}
+	return wildcard(pattern+"*", p)
+}
+
+// wildcard reports whether pattern, in which * matches any sequence, matches all of p
+func wildcard(pattern, p string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return p == pattern
+	}
+	if !strings.HasPrefix(p, parts[0]) {
+		return false
+	}
+	p = p[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		j := strings.Index(p, part)
+		if j < 0 {
+			return false
+		}
+		p = p[j+len(part):]
+	}
+	return strings.HasSuffix(p, parts[len(parts)-1])
+}
+
+// robotsCache fetches the robots.txt of each host until it gets an answer that holds
+type robotsCache struct {
+	mu    sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+type robotsEntry struct {
+	mu      sync.Mutex
+	fetched bool
+	r       *robots
+}
+
+// get returns the rules for u as RFC 9309 has them: a robots.txt that is unreachable or fails
+// with a 5xx disallows everything and is asked for again next time, one that is missing or
+// fails with another 4xx allows everything
+func (c *robotsCache) get(ctx context.Context, b *batch, u *url.URL) *robots {
+	key := u.Scheme + "://" + strings.ToLower(u.Host)
+	c.mu.Lock()
+	e := c.hosts[key]
+	if e == nil {
+		e = &robotsEntry{}
+		c.hosts[key] = e
+	}
+	c.mu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.fetched {
+		return e.r
+	}
+	resp, err := b.send(ctx, key+"/robots.txt", nil)
+	if err != nil {
+		return disallowAll
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return disallowAll
+	case resp.StatusCode == http.StatusOK:
+		r, err := parseRobots(io.LimitReader(resp.Body, 512<<10))
+		if err != nil {
+			return disallowAll
+		}
+		e.r = r
+	}
+	e.fetched = true
+	return e.r
+}
@@ -0,0 +1,284 @@
+package gget
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduler queues jobs and hands them to routines, skipping jobs whose host is at its
+// connection limit or still within its politeness delay so routines keep busy on other hosts
+type scheduler struct {
+	maxPerHost int
+	delay      time.Duration
+
+	mu      sync.Mutex
+	pending []job
+	added   int
+	open    int
+	closed  bool
+	active  map[string]int
+	next    map[string]time.Time
+	changed chan struct{}
+}
+
+func newScheduler(maxPerHost int, delay time.Duration) *scheduler {
+	return &scheduler{
+		maxPerHost: maxPerHost,
+		delay:      delay,
+		active:     make(map[string]int),
+		next:       make(map[string]time.Time),
+		changed:    make(chan struct{}),
+	}
+}
+
+// hostOf returns the host, with port, of link
+func hostOf(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// notify wakes the routines waiting in take, s.mu must be held
+func (s *scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// add queues j, numbering it in the order jobs are added
+func (s *scheduler) add(j job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.index = s.added
+	s.added++
+	s.open++
+	s.insert(j)
+}
+
+// requeue queues j again under the number it was given, after it was removed
+func (s *scheduler) requeue(j job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open++
+	s.insert(j)
+}
+
+// insert keeps the queue ordered by priority, then by number, s.mu must be held
+func (s *scheduler) insert(j job) {
+	i := sort.Search(len(s.pending), func(i int) bool {
+		p := s.pending[i]
+		return p.Priority < j.Priority || (p.Priority == j.Priority && p.index > j.index)
+	})
+	s.pending = append(s.pending, job{})
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = j
+	s.notify()
+}
+
+// remove takes the job of h out of the queue, false when it is not queued
+func (s *scheduler) remove(h *JobHandle) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.pending {
+		if j.h == h {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			s.open--
+			s.notify()
+			return j, true
+		}
+	}
+	return job{}, false
+}
+
+// reprioritize moves the job of h within the queue to the place of priority, false when it is
+// not queued
+func (s *scheduler) reprioritize(h *JobHandle, priority int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.pending {
+		if j.h == h {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			j.Priority = priority
+			s.insert(j)
+			return true
+		}
+	}
+	return false
+}
+
+// finish records that a job taken is over, after the jobs it led to were added
+func (s *scheduler) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open--
+	s.notify()
+}
+
+// close marks the end of the input, take reports false once every job is finished
+func (s *scheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.notify()
+}
+
+// idle blocks until every job added is finished, or ctx is done
+func (s *scheduler) idle(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.open == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// drain removes and returns the jobs still queued
+func (s *scheduler) drain() []job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pending
+	s.pending = nil
+	s.open -= len(pending)
+	s.notify()
+	return pending
+}
+
+// admit takes a connection of host if it is free now, otherwise it returns when the host's
+// delay runs out, or the zero time when it waits for a connection, s.mu must be held
+func (s *scheduler) admit(host string, now time.Time) (bool, time.Time) {
+	if s.maxPerHost > 0 && s.active[host] >= s.maxPerHost {
+		return false, time.Time{}
+	}
+	if next := s.next[host]; now.Before(next) {
+		return false, next
+	}
+	s.active[host]++
+	return true, time.Time{}
+}
+
+// take returns the first queued job, by priority, whose host can be connected to, taking a connection of
+// its host that must be given back with done, false means every job is finished or ctx is done
+func (s *scheduler) take(ctx context.Context) (job, bool) {
+	for {
+		s.mu.Lock()
+		if ctx.Err() != nil || (s.closed && s.open == 0) {
+			s.mu.Unlock()
+			return job{}, false
+		}
+		now := time.Now()
+		var wake time.Time
+		for i, j := range s.pending {
+			ok, at := s.admit(hostOf(j.URL), now)
+			if ok {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				s.mu.Unlock()
+				return j, true
+			}
+			if !at.IsZero() && (wake.IsZero() || at.Before(wake)) {
+				wake = at
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		var (
+			t       *time.Timer
+			timeout <-chan time.Time
+		)
+		if !wake.IsZero() {
+			t = time.NewTimer(time.Until(wake))
+			timeout = t.C
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-ctx.Done():
+		}
+		if t != nil {
+			// stopped each round, a deferred stop would keep every timer until take returns
+			t.Stop()
+		}
+	}
+}
+
+// acquire takes a connection of host, waiting for one to be free
+func (s *scheduler) acquire(ctx context.Context, host string) error {
+	for {
+		s.mu.Lock()
+		if s.maxPerHost <= 0 || s.active[host] < s.maxPerHost {
+			s.active[host]++
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tryAcquire takes a connection of host for a segment if one is free
+func (s *scheduler) tryAcquire(host string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxPerHost > 0 && s.active[host] >= s.maxPerHost {
+		return false
+	}
+	s.active[host]++
+	return true
+}
+
+// done gives back a connection of host
+func (s *scheduler) done(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active[host]--
+	if s.active[host] <= 0 {
+		delete(s.active, host)
+	}
+	s.notify()
+}
+
+// wait blocks until a request to host keeps the politeness delay to the one before it
+func (s *scheduler) wait(ctx context.Context, host string) error {
+	if s.delay <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	now := time.Now()
+	at := s.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	s.next[host] = at.Add(s.delay)
+	s.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
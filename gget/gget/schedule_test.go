@@ -0,0 +1,106 @@
+package gget
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostLimits(t *testing.T) {
+	// server counts the requests in flight and records when each arrives
+	type server struct {
+		*httptest.Server
+		mu       sync.Mutex
+		inFlight int
+		peak     int
+		arrivals []time.Time
+	}
+	newServer := func(prefix string, hold time.Duration) *server {
+		s := &server{}
+		s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.mu.Lock()
+			s.inFlight++
+			if s.inFlight > s.peak {
+				s.peak = s.inFlight
+			}
+			s.arrivals = append(s.arrivals, time.Now())
+			s.mu.Unlock()
+			time.Sleep(hold)
+			serveFile(prefix+r.URL.Path[1:])(w, r)
+			s.mu.Lock()
+			s.inFlight--
+			s.mu.Unlock()
+		}))
+		return s
+	}
+	input := func(n int, srvs ...*server) string {
+		var b strings.Builder
+		for _, srv := range srvs {
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(&b, "%s/f%d.bin\n", srv.URL, i)
+			}
+		}
+		return b.String()
+	}
+
+	t.Run("test connections per host", func(t *testing.T) {
+		a, b := newServer("a", 50*time.Millisecond), newServer("b", 0)
+		defer a.Close()
+		defer b.Close()
+		// the urls of b come last, routines must not idle behind the cap of a
+		if err := Get(context.Background(), strings.NewReader(input(6, a)+input(2, b)), 4, t.TempDir(), WithHostConnections(2)); err != nil {
+			t.Fatal(err)
+		}
+		if a.peak > 2 || b.peak > 2 {
+			t.Errorf("wanted at most 2 connections per host but got %d and %d", a.peak, b.peak)
+		}
+		if first := b.arrivals[0]; !first.Before(a.arrivals[len(a.arrivals)-1]) {
+			t.Errorf("expected the urls of the second host to be fetched while the first is busy")
+		}
+	})
+
+	t.Run("test connections per mirror host", func(t *testing.T) {
+		mirror := newServer("m", 50*time.Millisecond)
+		defer mirror.Close()
+		var in strings.Builder
+		for i := 0; i < 4; i++ {
+			// every url is on a host of its own, all of them fail over to the one mirror
+			primary := httptest.NewServer(http.NotFoundHandler())
+			defer primary.Close()
+			fmt.Fprintf(&in, `{"url": "%s/f%d.bin", "mirrors": ["%s/f%d.bin"]}`+"\n", primary.URL, i, mirror.URL, i)
+		}
+		if err := Get(context.Background(), strings.NewReader(in.String()), 4, t.TempDir(), WithHostConnections(1)); err != nil {
+			t.Fatal(err)
+		}
+		if mirror.peak > 1 {
+			t.Errorf("wanted at most 1 connection to the mirror but got %d", mirror.peak)
+		}
+	})
+
+	t.Run("test delay per host", func(t *testing.T) {
+		a, b := newServer("a", 0), newServer("b", 0)
+		defer a.Close()
+		defer b.Close()
+		delay := 50 * time.Millisecond
+		start := time.Now()
+		if err := Get(context.Background(), strings.NewReader(input(3, a, b)), 4, t.TempDir(), WithHostDelay(delay)); err != nil {
+			t.Fatal(err)
+		}
+		for _, srv := range []*server{a, b} {
+			for i := 1; i < len(srv.arrivals); i++ {
+				if gap := srv.arrivals[i].Sub(srv.arrivals[i-1]); gap < delay-5*time.Millisecond {
+					t.Errorf("wanted requests %v apart but got %v", delay, gap)
+				}
+			}
+		}
+		// both hosts get a HEAD and a GET per file, spaced in parallel
+		if elapsed, want := time.Since(start), 5*delay; elapsed > 2*want {
+			t.Errorf("expected the hosts to be waited for in parallel, took %v", elapsed)
+		}
+	})
+}
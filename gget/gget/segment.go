@@ -0,0 +1,401 @@
+package gget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// minSegmentSize is the smallest range a segment is split into
+var minSegmentSize int64 = 4 << 20
+
+var errRangeUnsupported = errors.New("server does not support ranges")
+
+// slots is the budget of concurrent connections, shared between whole files and segments
+type slots chan struct{}
+
+func newSlots(n int) slots {
+	return make(slots, n)
+}
+
+func (s slots) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s slots) tryAcquire() bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s slots) release() {
+	<-s
+}
+
+// segment is the range [Next, End) of a file still to be fetched
+type segment struct {
+	Next int64 `json:"next"`
+	End  int64 `json:"end"`
+
+	owned bool
+}
+
+// probe asks for the headers of link, with the extra header, and reports whether the file is
+// worth segmenting, the response is returned for any 2xx or 304 status
+func (b *batch) probe(ctx context.Context, link string, header http.Header) (*http.Response, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, nil)
+	if err != nil {
+		return nil, false
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, false
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, false
+	}
+	if !strings.Contains(resp.Header.Get("Accept-Ranges"), "bytes") || resp.ContentLength < 2*minSegmentSize || gzipEncoded(resp) {
+		return resp, false
+	}
+	return resp, true
+}
+
+// segmenter hands out segments of one file to the routines fetching it and records their progress
+type segmenter struct {
+	mu    sync.Mutex
+	f     *os.File
+	part  string
+	st    *partState
+	since int64
+	tr    *transfer
+}
+
+// next returns an unowned segment, or splits the largest remaining one in two and returns its
+// second half, nil means there is nothing left worth fetching in parallel
+func (sg *segmenter) next() *segment {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	var largest *segment
+	for _, seg := range sg.st.Segments {
+		if seg.Next >= seg.End {
+			continue
+		}
+		if !seg.owned {
+			seg.owned = true
+			return seg
+		}
+		if largest == nil || seg.End-seg.Next > largest.End-largest.Next {
+			largest = seg
+		}
+	}
+	if largest == nil || largest.End-largest.Next < 2*minSegmentSize {
+		return nil
+	}
+	mid := largest.Next + (largest.End-largest.Next)/2
+	seg := &segment{Next: mid, End: largest.End, owned: true}
+	largest.End = mid
+	sg.st.Segments = append(sg.st.Segments, seg)
+	return seg
+}
+
+// splittable reports whether next would hand out a segment
+func (sg *segmenter) splittable() bool {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	for _, seg := range sg.st.Segments {
+		if seg.Next < seg.End && (!seg.owned || seg.End-seg.Next >= 2*minSegmentSize) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed returns how many of n bytes at seg.Next still belong to seg
+func (sg *segmenter) allowed(seg *segment, n int) int {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	if rem := seg.End - seg.Next; int64(n) > rem {
+		return int(rem)
+	}
+	return n
+}
+
+// advance records n bytes written at seg.Next and checkpoints the state every checkpointEvery bytes
+func (sg *segmenter) advance(seg *segment, n int) error {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	counted := int64(n)
+	if seg.Next+counted > seg.End {
+		// the segment was split while writing, the bytes past End are rewritten by its new owner
+		counted = seg.End - seg.Next
+		if counted < 0 {
+			counted = 0
+		}
+	}
+	seg.Next += counted
+	sg.st.Received += counted
+	sg.tr.add(int(counted))
+	sg.since += counted
+	if sg.since < checkpointEvery {
+		return nil
+	}
+	sg.since = 0
+	return sg.checkpoint()
+}
+
+// checkpoint makes the written bytes durable before recording them, sg.mu must be held
+func (sg *segmenter) checkpoint() error {
+	if err := sg.f.Sync(); err != nil {
+		return fmt.Errorf("file sync %s failed with %v", sg.part, err)
+	}
+	return sg.st.save(sg.part)
+}
+
+// release gives up seg for another routine to fetch what is left of it
+func (sg *segmenter) release(seg *segment) {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	seg.owned = false
+}
+
+func (sg *segmenter) done() bool {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	for _, seg := range sg.st.Segments {
+		if seg.Next < seg.End {
+			return false
+		}
+	}
+	return true
+}
+
+// downloadSegmented fetches j, described by the probed head response, in ranges fetched
+// concurrently by this routine and as many helpers as sem and the host's connection cap allow.
+// With mirror segments the helpers take turns among the url and the mirrors of j, a mirror
+// failing is only left out of the turns.
+func (b *batch) downloadSegmented(ctx context.Context, j Job, head *http.Response, res *Result) error {
+	link := j.URL
+	res.StatusCode = head.StatusCode
+	path, skip, err := b.target(j, head.Header, res)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return skipped(path, res)
+	}
+	part := path + partExt
+	size := head.ContentLength
+	st := &partState{
+		URL:          link,
+		ETag:         head.Header.Get("ETag"),
+		LastModified: head.Header.Get("Last-Modified"),
+		Size:         size,
+		Segments:     []*segment{{Next: 0, End: size}},
+	}
+	if prev, err := loadPartState(part); err == nil && prev.Size == size && prev.matches(link, head) {
+		if fi, err := os.Stat(part); err == nil && fi.Size() <= size {
+			if prev.Segments == nil {
+				prev.Segments = []*segment{{Next: prev.Received, End: size}}
+			}
+			st = prev
+		}
+	}
+	validator := st.validator()
+
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("file create %s failed with %v", part, err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("file truncate %s failed with %v", part, err)
+	}
+	sg := &segmenter{f: f, part: part, st: st, tr: res.tr}
+	res.tr.begin(path, st.Received, size)
+	if err := sg.st.save(part); err != nil {
+		return fmt.Errorf("part state %s save failed with %v", part, err)
+	}
+
+	segCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	srcs := []string{link}
+	if b.cfg.mirrorSegments {
+		srcs = j.sources()
+	}
+	var (
+		srcMu sync.Mutex
+		turn  int
+		bad   = make(map[string]bool)
+	)
+	// pick returns the url the next helper fetches from
+	pick := func() string {
+		srcMu.Lock()
+		defer srcMu.Unlock()
+		for range srcs {
+			turn++
+			if src := srcs[turn%len(srcs)]; !bad[src] {
+				return src
+			}
+		}
+		return link
+	}
+	var spawn func()
+	run := func(src string) {
+		v := validator
+		if src != link {
+			// validators differ between mirrors, the size and the checksum tell them apart
+			v = ""
+		}
+		for seg := sg.next(); seg != nil && segCtx.Err() == nil; seg = sg.next() {
+			if err := b.fetchSegment(segCtx, src, v, b.jobHeader(j, res.origin, src, nil), size, sg, seg); err != nil {
+				if src != link && segCtx.Err() == nil {
+					srcMu.Lock()
+					bad[src] = true
+					srcMu.Unlock()
+					sg.release(seg)
+					return
+				}
+				fail(err)
+				return
+			}
+			spawn()
+		}
+	}
+	// spawn starts helpers on free slots while there is work to split, it is called whenever a
+	// segment finishes so slots freed by other files are picked up
+	spawn = func() {
+		for segCtx.Err() == nil && sg.splittable() && b.sem.tryAcquire() {
+			src := pick()
+			host := hostOf(src)
+			if !b.sched.tryAcquire(host) {
+				b.sem.release()
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer b.sem.release()
+				defer b.sched.done(host)
+				run(src)
+			}()
+		}
+	}
+	// segments given up by failed mirrors are fetched again once the helpers are done
+	for {
+		spawn()
+		run(link)
+		wg.Wait()
+		if segCtx.Err() != nil || sg.done() || !sg.splittable() {
+			break
+		}
+	}
+
+	sg.mu.Lock()
+	cerr := sg.checkpoint()
+	res.Bytes = st.Received
+	sg.mu.Unlock()
+	if firstErr != nil {
+		return firstErr
+	}
+	if cerr != nil {
+		return cerr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !sg.done() {
+		return fmt.Errorf("%w of %s, received %d of %d bytes", errIncomplete, link, st.Received, size)
+	}
+	res.Bytes = size
+	return b.complete(j, f, part, path, b.checksum(j, path, res), nil, st, res)
+}
+
+// fetchSegment fetches seg until it is complete, which may be sooner than requested when it is
+// split, with the extra header of its job to src
+func (b *batch) fetchSegment(ctx context.Context, link, validator string, extra http.Header, size int64, sg *segmenter, seg *segment) error {
+	sg.mu.Lock()
+	rng := fmt.Sprintf("bytes=%d-%d", seg.Next, seg.End-1)
+	next := seg.Next
+	sg.mu.Unlock()
+	header := http.Header{"Range": {rng}}
+	if validator != "" {
+		header.Set("If-Range", validator)
+	}
+	addMissing(header, extra)
+	resp, err := b.send(ctx, link, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		if resp.StatusCode == http.StatusOK {
+			return errRangeUnsupported
+		}
+		return newStatusError(link, resp)
+	}
+	if first, total, err := contentRange(resp.Header.Get("Content-Range")); err != nil || first != next || total >= 0 && total != size {
+		return fmt.Errorf("%w of %s, range %s got content range %q", errIncomplete, link, rng, resp.Header.Get("Content-Range"))
+	}
+
+	body := b.limit.reader(ctx, link, resp.Body)
+	buf := make([]byte, 32<<10)
+	off := next
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			n = sg.allowed(seg, n)
+			if n == 0 {
+				return nil
+			}
+			if _, err := sg.f.WriteAt(buf[:n], off); err != nil {
+				return fmt.Errorf("file write %s failed with %v", sg.part, err)
+			}
+			off += int64(n)
+			if err := sg.advance(seg, n); err != nil {
+				return err
+			}
+		}
+		if rerr == io.EOF {
+			sg.mu.Lock()
+			defer sg.mu.Unlock()
+			if seg.Next < seg.End {
+				return fmt.Errorf("%w of %s, range %s ended early at %d", errIncomplete, link, rng, seg.Next)
+			}
+			return nil
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSegmented(t *testing.T) {
+	defer func(n int64) { minSegmentSize = n }(minSegmentSize)
+	minSegmentSize = 8 << 10
+
+	t.Run("test ranges fetched concurrently", func(t *testing.T) {
+		var (
+			mu     sync.Mutex
+			ranges []string
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rng := r.Header.Get("Range"); rng != "" {
+				mu.Lock()
+				ranges = append(ranges, rng)
+				mu.Unlock()
+			}
+			serveFile("data.bin")(w, r)
+		}))
+		defer srv.Close()
+		outdir := t.TempDir()
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 4, outdir); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(outdir, "data.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("segmented file differs from payload")
+		}
+		if len(ranges) < 2 {
+			t.Errorf("wanted several range requests but got %v", ranges)
+		}
+		if _, err := os.Stat(filepath.Join(outdir, "data.bin.part.json")); err == nil {
+			t.Errorf("part state should be removed after completion")
+		}
+	})
+
+	t.Run("test resume of remaining segments", func(t *testing.T) {
+		srv := httptest.NewServer(serveFile("data.bin"))
+		defer srv.Close()
+		outdir := t.TempDir()
+		part := filepath.Join(outdir, "data.bin.part")
+		half := int64(len(payload) / 2)
+		stale := make([]byte, len(payload))
+		copy(stale, payload[:half])
+		if err := os.WriteFile(part, stale, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		st := &partState{URL: srv.URL, ETag: `"v1"`, Size: int64(len(payload)), Received: half,
+			Segments: []*segment{{Next: half, End: int64(len(payload))}}}
+		if err := st.save(part); err != nil {
+			t.Fatal(err)
+		}
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 2, outdir); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(outdir, "data.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("resumed file differs from payload")
+		}
+	})
+
+	t.Run("test bytes past a split counted once", func(t *testing.T) {
+		seg := &segment{Next: 0, End: 100, owned: true}
+		sg := &segmenter{st: &partState{Size: 100, Segments: []*segment{seg}}}
+		seg.End = 60 // split by another routine while 80 bytes were written
+		if err := sg.advance(seg, 80); err != nil {
+			t.Fatal(err)
+		}
+		if seg.Next != 60 || sg.st.Received != 60 {
+			t.Errorf("wanted next and received 60 but got %d and %d", seg.Next, sg.st.Received)
+		}
+	})
+
+	t.Run("test fallback when get ignores ranges", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Disposition", `attachment; filename="data.bin"`)
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			if r.Method == http.MethodGet {
+				w.Write(payload)
+			}
+		}))
+		defer srv.Close()
+		outdir := t.TempDir()
+		if err := Get(context.Background(), strings.NewReader(srv.URL+"\n"), 4, outdir); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(outdir, "data.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("file differs from payload")
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package gget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MirrorOrder is the order the urls of a job, its URL and Mirrors, are tried in
+type MirrorOrder int
+
+const (
+	// MirrorsInOrder tries URL and then the mirrors as listed
+	MirrorsInOrder MirrorOrder = iota
+	// MirrorsFastest tries the hosts not measured yet first, as listed, and then the others
+	// by the speed measured downloading from them during the batch
+	MirrorsFastest
+)
+
+var mirrorOrderNames = []string{"in-order", "fastest"}
+
+func (o MirrorOrder) String() string {
+	if o < 0 || int(o) >= len(mirrorOrderNames) {
+		return fmt.Sprintf("MirrorOrder(%d)", int(o))
+	}
+	return mirrorOrderNames[o]
+}
+
+// ParseMirrorOrder parses in-order or fastest
+func ParseMirrorOrder(s string) (MirrorOrder, error) {
+	for i, name := range mirrorOrderNames {
+		if s == name {
+			return MirrorOrder(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown mirror order %q, expected one of %s", s, strings.Join(mirrorOrderNames, ", "))
+}
+
+// sources returns the url and the mirrors of j. Mirrors on the local file system are left out,
+// a mirror list from a metalink of a remote server must not reach local files.
+func (j Job) sources() []string {
+	srcs := []string{j.URL}
+	for _, m := range j.Mirrors {
+		if u, err := url.Parse(m); err == nil && strings.EqualFold(u.Scheme, "file") {
+			continue
+		}
+		srcs = append(srcs, m)
+	}
+	return srcs
+}
+
+// from returns j fetched from src, with the other urls of j as its mirrors
+func (j Job) from(src string) Job {
+	all := j.sources()
+	j.URL, j.Mirrors = src, nil
+	for _, s := range all {
+		if s != src {
+			j.Mirrors = append(j.Mirrors, s)
+		}
+	}
+	return j
+}
+
+// speeds are the download speeds measured per host in bytes per second
+type speeds struct {
+	mu    sync.Mutex
+	hosts map[string]float64
+}
+
+// record measures n bytes received from the host of link in d
+func (s *speeds) record(link string, n int64, d time.Duration) {
+	if n <= 0 || d <= 0 {
+		return
+	}
+	host := hostOf(link)
+	rate := float64(n) / d.Seconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hosts[host] = smooth(s.hosts[host], rate)
+}
+
+// order returns the sources of j in the order they are tried
+func (s *speeds) order(j Job, order MirrorOrder) []string {
+	srcs := j.sources()
+	if order != MirrorsFastest || len(srcs) == 1 {
+		return srcs
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	speed := make(map[string]float64, len(srcs))
+	for _, src := range srcs {
+		speed[src] = s.hosts[hostOf(src)]
+	}
+	sort.SliceStable(srcs, func(a, b int) bool {
+		sa, sb := speed[srcs[a]], speed[srcs[b]]
+		if sa == 0 || sb == 0 {
+			return sa == 0 && sb != 0
+		}
+		return sa > sb
+	})
+	return srcs
+}
+
+// failover reports whether another url of a job may succeed where one failed with err
+func failover(err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, ErrExists)
+}
+
+// MirrorsError is returned when every url of a job failed, it wraps the last failure
+type MirrorsError struct {
+	URL string
+	// Errs are the failures in the order the urls were tried
+	Errs []error
+}
+
+func (e *MirrorsError) Error() string {
+	return fmt.Sprintf("all %d urls of %s failed, the last with %v", len(e.Errs), e.URL, e.Errs[len(e.Errs)-1])
+}
+
+func (e *MirrorsError) Unwrap() error {
+	return e.Errs[len(e.Errs)-1]
+}
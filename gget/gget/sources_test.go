@@ -0,0 +1,123 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMirrors(t *testing.T) {
+	sum := fmt.Sprintf("sha256=%x", sha256.Sum256(payload))
+	// counted serves payload, counting the requests and the range requests it gets
+	type counted struct {
+		*httptest.Server
+		mu           sync.Mutex
+		gets, ranges int
+	}
+	newServer := func(h http.HandlerFunc) *counted {
+		c := &counted{}
+		c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.mu.Lock()
+			if r.Method == http.MethodGet {
+				c.gets++
+				if r.Header.Get("Range") != "" {
+					c.ranges++
+				}
+			}
+			c.mu.Unlock()
+			h(w, r)
+		}))
+		return c
+	}
+	down := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}
+	corrupt := func(w http.ResponseWriter, r *http.Request) {
+		bad := bytes.Repeat([]byte("x"), len(payload))
+		http.ServeContent(w, r, "f.bin", time.Time{}, bytes.NewReader(bad))
+	}
+	once := RetryPolicy{MaxAttempts: 1}
+
+	t.Run("test failover on error", func(t *testing.T) {
+		a, b := newServer(down), newServer(serveFile("f.bin"))
+		defer a.Close()
+		defer b.Close()
+		rep, err := Fetch(context.Background(), strings.NewReader(a.URL+"/f.bin "+b.URL+"/f.bin\n"), 1, t.TempDir(), WithRetry(once))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := rep.Results[0]; res.Source != b.URL+"/f.bin" || res.URL != a.URL+"/f.bin" {
+			t.Errorf("wanted the file from the mirror but got %+v", res)
+		}
+	})
+
+	t.Run("test failover on checksum mismatch", func(t *testing.T) {
+		a, b := newServer(corrupt), newServer(serveFile("f.bin"))
+		defer a.Close()
+		defer b.Close()
+		dir := t.TempDir()
+		rep, err := Fetch(context.Background(), strings.NewReader(a.URL+"/f.bin "+b.URL+"/f.bin "+sum+"\n"), 1, dir, WithRetry(once))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := rep.Results[0]; res.Source != b.URL+"/f.bin" {
+			t.Errorf("wanted the file from the mirror but got %+v", res)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dir, "f.bin")); !bytes.Equal(got, payload) {
+			t.Errorf("expected the verified file")
+		}
+	})
+
+	t.Run("test all mirrors failing", func(t *testing.T) {
+		a, b := newServer(down), newServer(down)
+		defer a.Close()
+		defer b.Close()
+		err := Get(context.Background(), strings.NewReader(a.URL+"/f.bin "+b.URL+"/f.bin\n"), 1, t.TempDir(), WithRetry(once))
+		var me *MirrorsError
+		var se *StatusError
+		if !errors.As(err, &me) || len(me.Errs) != 2 || !errors.As(err, &se) {
+			t.Errorf("expected a MirrorsError wrapping the status errors but got %v", err)
+		}
+	})
+
+	t.Run("test fastest order", func(t *testing.T) {
+		s := speeds{hosts: map[string]float64{"slow": 10, "fast": 1000}}
+		j := Job{URL: "http://slow/f", Mirrors: []string{"http://fast/f", "http://new/f"}}
+		if got := strings.Join(s.order(j, MirrorsFastest), " "); got != "http://new/f http://fast/f http://slow/f" {
+			t.Errorf("unexpected order %s", got)
+		}
+		if got := s.order(j, MirrorsInOrder)[0]; got != "http://slow/f" {
+			t.Errorf("unexpected first url %s", got)
+		}
+	})
+
+	t.Run("test segments from several mirrors", func(t *testing.T) {
+		defer func(n int64) { minSegmentSize = n }(minSegmentSize)
+		minSegmentSize = 8 << 10
+		a, b, c := newServer(serveFile("f.bin")), newServer(serveFile("f.bin")), newServer(down)
+		defer a.Close()
+		defer b.Close()
+		defer c.Close()
+		dir := t.TempDir()
+		in := a.URL + "/f.bin " + b.URL + "/f.bin " + c.URL + "/f.bin " + sum + "\n"
+		if err := Get(context.Background(), strings.NewReader(in), 4, dir, WithMirrorSegments()); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dir, "f.bin")); !bytes.Equal(got, payload) {
+			t.Errorf("expected the assembled file to match")
+		}
+		if a.ranges == 0 || b.ranges == 0 {
+			t.Errorf("expected ranges from both mirrors but got %d and %d", a.ranges, b.ranges)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package gget
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LinkMode is how the files of the store are linked to the paths the urls are saved at
+type LinkMode int
+
+const (
+	// LinkHard creates hardlinks, and symlinks where the store is on another file system
+	LinkHard LinkMode = iota
+	// LinkSymbolic creates relative symlinks
+	LinkSymbolic
+)
+
+var linkModeNames = []string{"hard", "symlink"}
+
+func (m LinkMode) String() string {
+	if m < 0 || int(m) >= len(linkModeNames) {
+		return fmt.Sprintf("LinkMode(%d)", int(m))
+	}
+	return linkModeNames[m]
+}
+
+// ParseLinkMode parses hard or symlink
+func ParseLinkMode(s string) (LinkMode, error) {
+	for i, name := range linkModeNames {
+		if s == name {
+			return LinkMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown link mode %q, expected one of %s", s, strings.Join(linkModeNames, ", "))
+}
+
+// storeIndex is the file in the store directory recording the digest of every url stored
+const storeIndex = "index.json"
+
+// storeEntry is what the content of a url was stored as
+type storeEntry struct {
+	// Digest is the hex sha256 of the content
+	Digest string `json:"digest"`
+	// Name is the file name the url was saved as
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// store keeps downloads by their sha256 in dir/sha256/ab/cdef..., linked to the paths they
+// are saved at, so identical content behind different urls is kept once. Its methods do
+// nothing on a nil store.
+type store struct {
+	dir  string
+	link LinkMode
+
+	mu      sync.Mutex
+	entries map[string]*storeEntry
+	// storing has the digests being moved into the store, closed once they are
+	storing map[string]chan struct{}
+}
+
+// loadStore reads the index of the store in dir, which is empty on the first run
+func loadStore(dir string, link LinkMode) (*store, error) {
+	s := &store{dir: dir, link: link, entries: make(map[string]*storeEntry), storing: make(map[string]chan struct{})}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, storeIndex))
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.entries); err != nil {
+		return nil, fmt.Errorf("store index %s parse failed with %v", storeIndex, err)
+	}
+	return s, nil
+}
+
+// blob returns the path of the content with digest
+func (s *store) blob(digest string) string {
+	return filepath.Join(s.dir, "sha256", digest[:2], digest[2:])
+}
+
+// known returns the entry of the content of j when the store has it, found by the url or a
+// mirror of j, or by its sha256 checksum
+func (s *store) known(j Job) *storeEntry {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, src := range j.sources() {
+		if e := s.entries[src]; e != nil && s.has(e.Digest) {
+			return e
+		}
+	}
+	if c := j.Checksum; c != nil && c.Algo == "sha256" {
+		if digest := hex.EncodeToString(c.Sum); s.has(digest) {
+			return &storeEntry{Digest: digest}
+		}
+	}
+	return nil
+}
+
+func (s *store) has(digest string) bool {
+	if len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := os.Stat(s.blob(digest))
+	return err == nil
+}
+
+// put moves the downloaded file of res into the store, unless the store has its content
+// already, links it back to its path and records the url of j
+func (s *store) put(j Job, res *Result) error {
+	if s == nil {
+		return nil
+	}
+	f, err := os.Open(res.Path)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("hash %s failed with %v", res.Path, err)
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	blob := s.blob(digest)
+	unlock := s.lockDigest(digest)
+	err = s.intern(res.Path, blob)
+	unlock()
+	if err != nil {
+		return err
+	}
+	if err := s.linkTo(blob, res.Path); err != nil {
+		return err
+	}
+	res.Digest = digest
+	return s.record(j.URL, &storeEntry{Digest: digest, Name: filepath.Base(res.Path), Size: size})
+}
+
+// linkTo replaces path with a link to blob
+func (s *store) linkTo(blob, path string) error {
+	tmp := path + ".gget-link"
+	os.Remove(tmp)
+	err := errors.New("symlinks asked for")
+	if s.link == LinkHard {
+		err = os.Link(blob, tmp)
+	}
+	if err != nil {
+		target, aerr := filepath.Abs(blob)
+		if aerr != nil {
+			return aerr
+		}
+		if dir, aerr := filepath.Abs(filepath.Dir(path)); aerr == nil {
+			if rel, rerr := filepath.Rel(dir, target); rerr == nil {
+				target = rel
+			}
+		}
+		if err := os.Symlink(target, tmp); err != nil {
+			return fmt.Errorf("link %s to the store failed with %v", path, err)
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+// record saves that link has the content of e
+func (s *store) record(link string, e *storeEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[link] = e
+	b, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// replaced whole so an interrupted run never leaves a torn index
+	tmp := filepath.Join(s.dir, storeIndex+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, storeIndex))
+}
+
+// fromStore links the content of j the store has to the path it is saved at instead of
+// downloading it, it reports whether it did
+func (b *batch) fromStore(ctx context.Context, j Job, res *Result) (bool, error) {
+	e := b.store.known(j)
+	if e == nil {
+		return false, nil
+	}
+	header := http.Header{}
+	if e.Name != "" {
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": e.Name}))
+	}
+	path, skip, err := b.target(j, header, res)
+	if err != nil {
+		return true, err
+	}
+	if skip {
+		return true, skipped(path, res)
+	}
+	if err := b.store.linkTo(b.store.blob(e.Digest), path); err != nil {
+		return true, err
+	}
+	res.Path, res.Digest, res.Skipped = path, e.Digest, true
+	if fi, err := os.Stat(path); err == nil {
+		res.Bytes = fi.Size()
+	}
+	if e.Name == "" {
+		// found by its checksum, the url is known from now on
+		e = &storeEntry{Digest: e.Digest, Name: filepath.Base(path), Size: res.Bytes}
+	}
+	if err := b.store.record(j.URL, e); err != nil {
+		return true, err
+	}
+	return true, b.extract(ctx, res)
+}
+
+// intern moves the file at path to blob unless the store has its content already, the copy at
+// path is then replaced by the link to blob. The digest of blob must be locked.
+func (s *store) intern(path, blob string) error {
+	if _, err := os.Stat(blob); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+		return err
+	}
+	if err := moveFile(path, blob); err != nil {
+		return fmt.Errorf("store %s failed with %v", path, err)
+	}
+	// every link shares the content, none may change it
+	return os.Chmod(blob, 0o444)
+}
+
+// lockDigest waits until no other routine stores digest and keeps the others waiting until
+// the returned func is called
+func (s *store) lockDigest(digest string) func() {
+	for {
+		s.mu.Lock()
+		busy, ok := s.storing[digest]
+		if !ok {
+			done := make(chan struct{})
+			s.storing[digest] = done
+			s.mu.Unlock()
+			return func() {
+				s.mu.Lock()
+				delete(s.storing, digest)
+				s.mu.Unlock()
+				close(done)
+			}
+		}
+		s.mu.Unlock()
+		<-busy
+	}
+}
+
+// moveFile renames src to dst, copying it where they are on different file systems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), ".gget-store-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(src)
+}
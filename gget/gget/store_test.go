@@ -0,0 +1,145 @@
+package gget
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			requests.Add(1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(payload))
+	}))
+	defer srv.Close()
+	storeDir := t.TempDir()
+	sum := sha256.Sum256(payload)
+	digest := fmt.Sprintf("%x", sum)
+	blob := filepath.Join(storeDir, "sha256", digest[:2], digest[2:])
+	fetch := func(t *testing.T, in string, link LinkMode) (*Report, string) {
+		t.Helper()
+		dir := t.TempDir()
+		rep, err := Fetch(context.Background(), strings.NewReader(in), 2, dir, WithStore(storeDir, link))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rep, dir
+	}
+	in := srv.URL + "/a.bin\n" + srv.URL + "/b.bin\n"
+
+	t.Run("test duplicates are stored once", func(t *testing.T) {
+		rep, dir := fetch(t, in, LinkHard)
+		if n := requests.Load(); n != 2 {
+			t.Fatalf("got %d requests", n)
+		}
+		bfi, err := os.Stat(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bfi.Mode().Perm() != 0o444 {
+			t.Errorf("stored with mode %v", bfi.Mode().Perm())
+		}
+		for _, name := range []string{"a.bin", "b.bin"} {
+			fi, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !os.SameFile(fi, bfi) {
+				t.Errorf("%s is not linked to the store", name)
+			}
+		}
+		for _, res := range rep.Results {
+			if res.Digest != digest || res.Skipped {
+				t.Errorf("got %+v", res)
+			}
+		}
+		entries, _ := os.ReadDir(filepath.Join(storeDir, "sha256"))
+		if len(entries) != 1 {
+			t.Errorf("store has %d prefixes", len(entries))
+		}
+	})
+
+	t.Run("test known urls and checksums are linked without downloading", func(t *testing.T) {
+		before := requests.Load()
+		rep, dir := fetch(t, in+srv.URL+"/c.bin sha256="+digest+"\n", LinkSymbolic)
+		if n := requests.Load(); n != before {
+			t.Fatalf("downloaded %d urls again", n-before)
+		}
+		for i, name := range []string{"a.bin", "b.bin", "c.bin"} {
+			p := filepath.Join(dir, name)
+			fi, err := os.Lstat(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode()&fs.ModeSymlink == 0 {
+				t.Errorf("%s is not a symlink", name)
+			}
+			got, err := os.ReadFile(p)
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Errorf("%s: %v", name, err)
+			}
+			if res := rep.Results[i]; !res.Skipped || res.Digest != digest || res.Path != p {
+				t.Errorf("got %+v", res)
+			}
+		}
+		var index map[string]storeEntry
+		b, err := os.ReadFile(filepath.Join(storeDir, storeIndex))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &index); err != nil {
+			t.Fatal(err)
+		}
+		if e := index[srv.URL+"/c.bin"]; e.Digest != digest || e.Name != "c.bin" {
+			t.Errorf("index has %+v", e)
+		}
+	})
+
+	t.Run("test concurrent puts of the same content", func(t *testing.T) {
+		s, err := loadStore(t.TempDir(), LinkHard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			p := filepath.Join(dir, fmt.Sprintf("f%d.bin", i))
+			if err := os.WriteFile(p, payload, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			wg.Add(1)
+			go func(i int, p string) {
+				defer wg.Done()
+				if err := s.put(Job{URL: fmt.Sprintf("http://h/f%d.bin", i)}, &Result{Path: p}); err != nil {
+					t.Error(err)
+				}
+			}(i, p)
+		}
+		wg.Wait()
+		bfi, err := os.Stat(s.blob(digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 8; i++ {
+			fi, err := os.Stat(filepath.Join(dir, fmt.Sprintf("f%d.bin", i)))
+			if err != nil || !os.SameFile(fi, bfi) {
+				t.Errorf("f%d.bin is not linked to the store: %v", i, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package gget
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// TransportConfig configures the connections of the downloads, zero durations mean no timeout
+type TransportConfig struct {
+	// ConnectTimeout limits establishing a connection
+	ConnectTimeout time.Duration
+	// TLSHandshakeTimeout limits the TLS handshake
+	TLSHandshakeTimeout time.Duration
+	// HeaderTimeout limits the wait for the response headers once a request is sent
+	HeaderTimeout time.Duration
+	// IdleTimeout fails a response that delivers no data for this long, it is retried
+	IdleTimeout time.Duration
+	// Timeout limits each request including the reading of its body
+	Timeout time.Duration
+	// Proxy is the url of an http, https or socks5 proxy, the environment decides when empty
+	// as with HTTP_PROXY, and direct connects without any
+	Proxy string
+	// CAFile is a PEM file of root certificates added to the system ones
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key for mutual TLS
+	CertFile, KeyFile string
+	// InsecureSkipVerify accepts any server certificate
+	InsecureSkipVerify bool
+	// HTTPVersion forces "1.1" or "2", over TLS, the version is negotiated when empty. With "2"
+	// a server that only speaks HTTP/1.1 fails.
+	HTTPVersion string
+}
+
+// DefaultTransportConfig bounds connecting and waiting for responses so a stalled server
+// cannot hold a routine forever
+var DefaultTransportConfig = TransportConfig{
+	ConnectTimeout:      30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+	HeaderTimeout:       time.Minute,
+	IdleTimeout:         2 * time.Minute,
+}
+
+// client returns the http client configured by t, with jar if it is not nil
+func (t TransportConfig) client(jar http.CookieJar) (*http.Client, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = (&net.Dialer{Timeout: t.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	tr.TLSHandshakeTimeout = t.TLSHandshakeTimeout
+	tr.ResponseHeaderTimeout = t.HeaderTimeout
+
+	switch t.Proxy {
+	case "":
+	case "direct":
+		tr.Proxy = nil
+	default:
+		u, err := url.Parse(t.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q parse failed with %v", t.Proxy, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("proxy %q must be an http, https or socks5 url", t.Proxy)
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+
+	cfg, err := t.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	tr.TLSClientConfig = cfg
+
+	switch t.HTTPVersion {
+	case "":
+		tr.ForceAttemptHTTP2 = true
+	case "1.1":
+		tr.ForceAttemptHTTP2 = false
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case "2":
+		tr.ForceAttemptHTTP2 = true
+		cfg.NextProtos = []string{"h2"}
+		// the transport offers http/1.1 too, which a server without HTTP/2 picks
+		return &http.Client{Transport: http2Only{tr}, Jar: jar, Timeout: t.Timeout}, nil
+	default:
+		return nil, fmt.Errorf("unsupported http version %q, expected 1.1 or 2", t.HTTPVersion)
+	}
+
+	return &http.Client{Transport: tr, Jar: jar, Timeout: t.Timeout}, nil
+}
+
+// http2Only fails the https responses not served over HTTP/2
+type http2Only struct {
+	http.RoundTripper
+}
+
+func (t http2Only) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || req.URL.Scheme != "https" || resp.ProtoMajor == 2 {
+		return resp, err
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("%s answered over %s, HTTP/2 was required", req.URL.Host, resp.Proto)
+}
+
+// tlsConfig returns the TLS settings of t
+func (t TransportConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate load failed with %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// errIdle is the failure of a response that stopped delivering data, it is retryable
+var errIdle = fmt.Errorf("no data received: %w", context.DeadlineExceeded)
+
+// idleBody fails the reads of a response body once no data arrived for timeout, by
+// canceling the request
+type idleBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+
+	mu    sync.Mutex
+	fired bool
+}
+
+// watchIdle sends req with a context canceled when its response stalls for timeout
+func watchIdle(req *http.Request, timeout time.Duration, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := send(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	b := &idleBody{ReadCloser: resp.Body, timeout: timeout, cancel: cancel}
+	b.timer = time.AfterFunc(timeout, b.expire)
+	resp.Body = b
+	return resp, nil
+}
+
+func (b *idleBody) expire() {
+	b.mu.Lock()
+	b.fired = true
+	b.mu.Unlock()
+	b.cancel()
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	fired := b.fired
+	b.mu.Unlock()
+	if fired && err != nil && !errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("%w for %v", errIdle, b.timeout)
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
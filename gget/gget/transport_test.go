@@ -0,0 +1,168 @@
+package gget
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	fetch := func(in string, opts ...Option) (*Report, error) {
+		opts = append(opts, WithRetry(RetryPolicy{MaxAttempts: 1}))
+		return Fetch(context.Background(), strings.NewReader(in), 1, t.TempDir(), opts...)
+	}
+	writePEM := func(t *testing.T, typ string, der []byte) string {
+		t.Helper()
+		p := filepath.Join(t.TempDir(), "f.pem")
+		if err := os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	t.Run("test custom ca and insecure", func(t *testing.T) {
+		srv := httptest.NewTLSServer(serveFile("f.bin"))
+		defer srv.Close()
+		if rep, _ := fetch(srv.URL + "/f\n"); rep.Failed() != 1 {
+			t.Fatal("expected unknown authority failure")
+		}
+		ca := writePEM(t, "CERTIFICATE", srv.Certificate().Raw)
+		if _, err := fetch(srv.URL+"/f\n", WithTransport(TransportConfig{CAFile: ca})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fetch(srv.URL+"/f\n", WithTransport(TransportConfig{InsecureSkipVerify: true})); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("test client certificate", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "gget"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		pool := x509.NewCertPool()
+		pool.AddCert(cert)
+		srv := httptest.NewUnstartedServer(serveFile("f.bin"))
+		srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+		srv.StartTLS()
+		defer srv.Close()
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc := TransportConfig{InsecureSkipVerify: true}
+		if rep, _ := fetch(srv.URL+"/f\n", WithTransport(tc)); rep.Failed() != 1 {
+			t.Fatal("expected failure without a client certificate")
+		}
+		tc.CertFile, tc.KeyFile = writePEM(t, "CERTIFICATE", der), writePEM(t, "EC PRIVATE KEY", keyDER)
+		if _, err := fetch(srv.URL+"/f\n", WithTransport(tc)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("test http version", func(t *testing.T) {
+		var proto atomic.Int64
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proto.Store(int64(r.ProtoMajor))
+			serveFile("f.bin")(w, r)
+		}))
+		srv.EnableHTTP2 = true
+		srv.StartTLS()
+		defer srv.Close()
+		for version, want := range map[string]int64{"1.1": 1, "2": 2} {
+			tc := TransportConfig{InsecureSkipVerify: true, HTTPVersion: version}
+			if _, err := fetch(srv.URL+"/f\n", WithTransport(tc)); err != nil {
+				t.Fatal(err)
+			}
+			if got := proto.Load(); got != want {
+				t.Fatalf("http %s: got protocol %d", version, got)
+			}
+		}
+		if _, err := fetch(srv.URL+"/f\n", WithTransport(TransportConfig{HTTPVersion: "3"})); err == nil {
+			t.Fatal("expected unsupported version error")
+		}
+
+		old := httptest.NewTLSServer(http.HandlerFunc(serveFile("f.bin")))
+		defer old.Close()
+		if _, err := fetch(old.URL+"/f\n", WithTransport(TransportConfig{InsecureSkipVerify: true, HTTPVersion: "2"})); err == nil || !strings.Contains(err.Error(), "HTTP/2 was required") {
+			t.Fatalf("expected an HTTP/1.1 only server to fail but got %v", err)
+		}
+	})
+
+	t.Run("test proxy", func(t *testing.T) {
+		var hosts []string
+		var mu sync.Mutex
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hosts = append(hosts, r.URL.Host)
+			mu.Unlock()
+			serveFile("f.bin")(w, r)
+		}))
+		defer proxy.Close()
+		if _, err := fetch("http://files.example/f\n", WithTransport(TransportConfig{Proxy: proxy.URL})); err != nil {
+			t.Fatal(err)
+		}
+		if len(hosts) == 0 || hosts[0] != "files.example" {
+			t.Fatalf("proxy saw %v", hosts)
+		}
+		if _, err := fetch("http://files.example/f\n", WithTransport(TransportConfig{Proxy: "ftp://proxy"})); err == nil {
+			t.Fatal("expected unsupported proxy error")
+		}
+	})
+
+	t.Run("test idle timeout", func(t *testing.T) {
+		stop := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			if r.Method == http.MethodHead {
+				return
+			}
+			w.Write(payload[:100])
+			w.(http.Flusher).Flush()
+			select {
+			case <-stop:
+			case <-r.Context().Done():
+			}
+		}))
+		defer srv.Close()
+		defer close(stop)
+		start := time.Now()
+		rep, _ := fetch(srv.URL+"/f\n", WithTransport(TransportConfig{IdleTimeout: 100 * time.Millisecond}))
+		if rep.Failed() != 1 || !errors.Is(rep.Results[0].Err, context.DeadlineExceeded) {
+			t.Fatalf("expected idle failure, got %+v", rep.Results)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("idle timeout did not stop the download")
+		}
+	})
+}